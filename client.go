@@ -80,6 +80,7 @@ type Client struct {
 	UserID         id.UserID    // The user ID of the client. Used for forming HTTP paths which use the client's user ID.
 	DeviceID       id.DeviceID  // The device ID of the client.
 	AccessToken    string       // The access_token for the client.
+	RefreshToken   string       // The refresh_token for the client, if refresh tokens are used.
 	UserAgent      string       // The value for the User-Agent header
 	Client         *http.Client // The underlying HTTP client which will be used to make HTTP requests.
 	Syncer         Syncer       // The thing which can process /sync responses
@@ -110,6 +111,10 @@ type Client struct {
 	// Set to true to disable automatically sleeping on 429 errors.
 	IgnoreRateLimit bool
 
+	// Controls whether media downloads and thumbnails use the authenticated (/_matrix/client/v1/media)
+	// or the legacy (/_matrix/media/v3) endpoints. See [MediaEndpointMode] for details.
+	MediaEndpoints MediaEndpointMode
+
 	txnID int32
 
 	// Should the ?user_id= query parameter be set in requests?
@@ -654,6 +659,22 @@ func ParseErrorResponse(req *http.Request, res *http.Response) ([]byte, error) {
 	}
 }
 
+// parseRetryAfterBody parses the retry_after_ms field from a M_LIMIT_EXCEEDED response body.
+// This is used for servers that don't send the Retry-After header.
+func parseRetryAfterBody(res *http.Response, fallback time.Duration) time.Duration {
+	contents, err := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return fallback
+	}
+	var respErr RespError
+	if json.Unmarshal(contents, &respErr) != nil {
+		return fallback
+	} else if retryAfter, ok := respErr.RetryAfter(); ok {
+		return retryAfter
+	}
+	return fallback
+}
+
 func (cli *Client) executeCompiledRequest(req *http.Request, retries int, backoff time.Duration, responseJSON any, handler ClientResponseHandler, dontReadResponse bool, client *http.Client) ([]byte, *http.Response, error) {
 	cli.RequestStart(req)
 	startTime := time.Now()
@@ -678,7 +699,15 @@ func (cli *Client) executeCompiledRequest(req *http.Request, retries int, backof
 	}
 
 	if retries > 0 && retryafter.Should(res.StatusCode, !cli.IgnoreRateLimit) {
-		backoff = retryafter.Parse(res.Header.Get("Retry-After"), backoff)
+		if retryAfter := res.Header.Get("Retry-After"); retryAfter != "" {
+			backoff = retryafter.Parse(retryAfter, backoff)
+		} else if res.StatusCode == http.StatusTooManyRequests {
+			backoff = parseRetryAfterBody(res, backoff)
+		}
+		if dontReadResponse {
+			// The response isn't returned to the caller when retrying, so the body must be closed here
+			_ = res.Body.Close()
+		}
 		return cli.doRetry(req, fmt.Errorf("HTTP %d", res.StatusCode), retries, backoff, responseJSON, handler, dontReadResponse, client)
 	}
 
@@ -898,6 +927,20 @@ func (cli *Client) GetLoginFlows(ctx context.Context) (resp *RespLoginFlows, err
 	return
 }
 
+// BuildSSORedirectURL builds the URL that the user should be sent to in order to log in via SSO.
+// If idpID is empty, the homeserver will present its own identity provider picker.
+// After logging in, the user will be redirected to redirectURL with a loginToken query parameter,
+// which can be exchanged for an access token using [Client.Login] with the m.login.token type.
+//
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv3loginssoredirect
+func (cli *Client) BuildSSORedirectURL(idpID, redirectURL string) string {
+	urlPath := ClientURLPath{"v3", "login", "sso", "redirect"}
+	if idpID != "" {
+		urlPath = append(urlPath, idpID)
+	}
+	return cli.BuildURLWithQuery(urlPath, map[string]string{"redirectUrl": redirectURL})
+}
+
 // Login a user to the homeserver according to https://spec.matrix.org/v1.2/client-server-api/#post_matrixclientv3login
 func (cli *Client) Login(ctx context.Context, req *ReqLogin) (resp *RespLogin, err error) {
 	_, err = cli.MakeFullRequest(ctx, FullRequest{
@@ -910,6 +953,7 @@ func (cli *Client) Login(ctx context.Context, req *ReqLogin) (resp *RespLogin, e
 	if req.StoreCredentials && err == nil {
 		cli.DeviceID = resp.DeviceID
 		cli.AccessToken = resp.AccessToken
+		cli.RefreshToken = resp.RefreshToken
 		cli.UserID = resp.UserID
 
 		cli.Log.Debug().
@@ -934,6 +978,33 @@ func (cli *Client) Login(ctx context.Context, req *ReqLogin) (resp *RespLogin, e
 	return
 }
 
+// Refresh exchanges a refresh token for a new access token using https://spec.matrix.org/v1.11/client-server-api/#post_matrixclientv3refresh
+//
+// If the refresh token in the request is empty, the one stored in the client is used. If the server returns a new
+// refresh token, the old one is invalidated. With StoreCredentials, both the new access token and the new refresh
+// token are stored in the client, otherwise the caller must replace them.
+func (cli *Client) Refresh(ctx context.Context, req *ReqRefresh) (resp *RespRefresh, err error) {
+	if req.RefreshToken == "" {
+		req.RefreshToken = cli.RefreshToken
+	}
+	_, err = cli.MakeFullRequest(ctx, FullRequest{
+		Method:           http.MethodPost,
+		URL:              cli.BuildClientURL("v3", "refresh"),
+		RequestJSON:      req,
+		ResponseJSON:     &resp,
+		SensitiveContent: true,
+	})
+	if req.StoreCredentials && err == nil {
+		cli.AccessToken = resp.AccessToken
+		// The server may rotate the refresh token, in which case the old one can't be used again.
+		if resp.RefreshToken != "" {
+			cli.RefreshToken = resp.RefreshToken
+		}
+		cli.Log.Debug().Msg("Stored new access token after refresh")
+	}
+	return
+}
+
 // Create a device for an appservice user using MSC4190.
 func (cli *Client) CreateDeviceMSC4190(ctx context.Context, deviceID id.DeviceID, initialDisplayName string) error {
 	if len(deviceID) == 0 {
@@ -1684,10 +1755,57 @@ func (cli *Client) UploadLink(ctx context.Context, link string) (*RespMediaUploa
 	return cli.Upload(ctx, res.Body, res.Header.Get("Content-Type"), res.ContentLength)
 }
 
+// MediaEndpointMode specifies which endpoints are used for downloading media.
+type MediaEndpointMode int
+
+const (
+	// MediaEndpointsAuto uses authenticated media, unless [Client.SpecVersions] has been fetched
+	// and says the server doesn't support it.
+	MediaEndpointsAuto MediaEndpointMode = iota
+	// MediaEndpointsAuthenticated always uses the authenticated media endpoints.
+	MediaEndpointsAuthenticated
+	// MediaEndpointsLegacy always uses the legacy unauthenticated media endpoints.
+	MediaEndpointsLegacy
+)
+
+// SupportsAuthenticatedMedia checks whether media requests will use the authenticated media endpoints.
+// In [MediaEndpointsAuto] mode, the versions endpoint is called first if it hasn't been fetched yet.
+func (cli *Client) SupportsAuthenticatedMedia(ctx context.Context) (bool, error) {
+	if cli.MediaEndpoints == MediaEndpointsAuto && cli.SpecVersions == nil {
+		_, err := cli.Versions(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to fetch supported versions: %w", err)
+		}
+	}
+	return cli.useAuthenticatedMedia(), nil
+}
+
+func (cli *Client) useAuthenticatedMedia() bool {
+	switch cli.MediaEndpoints {
+	case MediaEndpointsAuthenticated:
+		return true
+	case MediaEndpointsLegacy:
+		return false
+	default:
+		return cli.SpecVersions == nil || cli.SpecVersions.Supports(FeatureAuthenticatedMedia)
+	}
+}
+
+func (cli *Client) buildMediaURL(endpoint string, mxcURL id.ContentURI, query map[string]string) string {
+	var urlPath PrefixableURLPath
+	if cli.useAuthenticatedMedia() {
+		urlPath = ClientURLPath{"v1", "media", endpoint, mxcURL.Homeserver, mxcURL.FileID}
+	} else {
+		urlPath = MediaURLPath{"v3", endpoint, mxcURL.Homeserver, mxcURL.FileID}
+	}
+	return cli.BuildURLWithQuery(urlPath, query)
+}
+
+// Download downloads the given mxc URI. The authenticated media endpoint is used unless disabled with [Client.MediaEndpoints].
 func (cli *Client) Download(ctx context.Context, mxcURL id.ContentURI) (*http.Response, error) {
 	_, resp, err := cli.MakeFullRequestWithResp(ctx, FullRequest{
 		Method:           http.MethodGet,
-		URL:              cli.BuildClientURL("v1", "media", "download", mxcURL.Homeserver, mxcURL.FileID),
+		URL:              cli.buildMediaURL("download", mxcURL, nil),
 		DontReadResponse: true,
 	})
 	return resp, err
@@ -1702,6 +1820,35 @@ func (cli *Client) DownloadBytes(ctx context.Context, mxcURL id.ContentURI) ([]b
 	return io.ReadAll(resp.Body)
 }
 
+// ThumbnailURL returns the URL for a thumbnail of the given mxc URI. The authenticated media endpoint is used
+// unless disabled with [Client.MediaEndpoints]. Requests to authenticated media URLs must include the access token
+// in the Authorization header, which [Client.Thumbnail] does automatically.
+func (cli *Client) ThumbnailURL(mxcURL id.ContentURI, req *ReqThumbnail) string {
+	return cli.buildMediaURL("thumbnail", mxcURL, req.Query())
+}
+
+// Thumbnail downloads a thumbnail of the given mxc URI.
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv1mediathumbnailservernamemediaid
+func (cli *Client) Thumbnail(ctx context.Context, mxcURL id.ContentURI, req *ReqThumbnail) (*http.Response, error) {
+	_, resp, err := cli.MakeFullRequestWithResp(ctx, FullRequest{
+		Method:           http.MethodGet,
+		URL:              cli.ThumbnailURL(mxcURL, req),
+		DontReadResponse: true,
+	})
+	return resp, err
+}
+
+// ThumbnailBytes downloads a thumbnail of the given mxc URI and returns the whole response body.
+// Use [Client.Thumbnail] to stream the response instead.
+func (cli *Client) ThumbnailBytes(ctx context.Context, mxcURL id.ContentURI, req *ReqThumbnail) ([]byte, error) {
+	resp, err := cli.Thumbnail(ctx, mxcURL, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
 type ReqCreateMXC struct {
 	BeeperUniqueID string
 	BeeperRoomID   id.RoomID
@@ -2505,6 +2652,20 @@ func (cli *Client) PutPushRule(ctx context.Context, scope string, kind pushrules
 	return err
 }
 
+// SetPushRuleEnabled enables or disables a push rule. See https://spec.matrix.org/v1.11/client-server-api/#put_matrixclientv3pushrulesscopekindruleidenabled
+func (cli *Client) SetPushRuleEnabled(ctx context.Context, scope string, kind pushrules.PushRuleType, ruleID string, enabled bool) error {
+	urlPath := cli.BuildClientURL("v3", "pushrules", scope, kind, ruleID, "enabled")
+	_, err := cli.MakeRequest(ctx, http.MethodPut, urlPath, &ReqPushRuleEnabled{Enabled: enabled}, nil)
+	return err
+}
+
+// SetPushRuleActions sets the actions of a push rule. See https://spec.matrix.org/v1.11/client-server-api/#put_matrixclientv3pushrulesscopekindruleidactions
+func (cli *Client) SetPushRuleActions(ctx context.Context, scope string, kind pushrules.PushRuleType, ruleID string, actions pushrules.PushActionArray) error {
+	urlPath := cli.BuildClientURL("v3", "pushrules", scope, kind, ruleID, "actions")
+	_, err := cli.MakeRequest(ctx, http.MethodPut, urlPath, &ReqPushRuleActions{Actions: actions}, nil)
+	return err
+}
+
 func (cli *Client) ReportEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID, reason string) error {
 	urlPath := cli.BuildClientURL("v3", "rooms", roomID, "report", eventID)
 	_, err := cli.MakeRequest(ctx, http.MethodPost, urlPath, &ReqReport{Reason: reason, Score: -100}, nil)
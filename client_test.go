@@ -0,0 +1,88 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestClient_RetryAfterBody(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1)%2 == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests","retry_after_ms":10}`))
+			return
+		}
+		if r.URL.Path == "/_matrix/client/v1/media/download/example.com/media" {
+			_, _ = w.Write([]byte("hello"))
+		} else {
+			_, _ = w.Write([]byte(`{"user_id":"@user:example.com"}`))
+		}
+	}))
+	defer server.Close()
+
+	cli, err := mautrix.NewClient(server.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	cli.DefaultHTTPRetries = 1
+	// The default backoff is long enough that the test would time out if retry_after_ms was ignored
+	cli.DefaultHTTPBackoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := cli.Whoami(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, "@user:example.com", resp.UserID)
+	assert.EqualValues(t, 2, attempts.Load())
+
+	// Requests that don't read the response must parse the rate limit body too
+	data, err := cli.DownloadBytes(ctx, id.ContentURI{Homeserver: "example.com", FileID: "media"})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	assert.EqualValues(t, 4, attempts.Load())
+}
+
+func TestClient_Refresh(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_matrix/client/v3/refresh", r.URL.Path)
+		var req mautrix.ReqRefresh
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		received = append(received, req.RefreshToken)
+		_, _ = fmt.Fprintf(w, `{"access_token":"access%d","refresh_token":"refresh%d","expires_in_ms":60000}`, len(received), len(received))
+	}))
+	defer server.Close()
+
+	cli, err := mautrix.NewClient(server.URL, "@user:example.com", "access0")
+	require.NoError(t, err)
+	cli.RefreshToken = "refresh0"
+
+	resp, err := cli.Refresh(context.Background(), &mautrix.ReqRefresh{StoreCredentials: true})
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, resp.ExpiresIn())
+	assert.Equal(t, "access1", cli.AccessToken)
+	assert.Equal(t, "refresh1", cli.RefreshToken)
+
+	// The rotated refresh token must be used for the next refresh
+	_, err = cli.Refresh(context.Background(), &mautrix.ReqRefresh{StoreCredentials: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"refresh0", "refresh1"}, received)
+	assert.Equal(t, "refresh2", cli.RefreshToken)
+}
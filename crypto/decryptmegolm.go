@@ -176,11 +176,13 @@ func (mach *OlmMachine) DecryptMegolmEvent(ctx context.Context, evt *event.Event
 		Content:   megolmEvt.Content,
 		Unsigned:  evt.Unsigned,
 		Mautrix: event.MautrixInfo{
-			TrustState:    trustLevel,
-			TrustSource:   device,
-			ForwardedKeys: forwardedKeys,
-			WasEncrypted:  true,
-			ReceivedAt:    evt.Mautrix.ReceivedAt,
+			TrustState:         trustLevel,
+			TrustSource:        device,
+			ForwardedKeys:      forwardedKeys,
+			UnverifiedSource:   sess.UnverifiedSource,
+			WasEncrypted:       true,
+			ReceivedAt:         evt.Mautrix.ReceivedAt,
+			MegolmMessageIndex: messageIndex,
 		},
 	}, nil
 }
@@ -341,11 +341,30 @@ func (mach *OlmMachine) unwedgeDevice(log zerolog.Logger, sender id.UserID, send
 	}
 
 	log.Debug().Str("device_id", deviceIdentity.DeviceID.String()).Msg("Creating new Olm session")
+	err = mach.ResetOlmSession(ctx, deviceIdentity)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unwedge session")
+	}
+}
+
+// ResetOlmSession creates a new Olm session with the given device and sends an m.dummy event over it,
+// which makes the other side switch to the new session too.
+//
+// Sessions are reset automatically when to-device messages from a device fail to decrypt, but only once per
+// [MinUnwedgeInterval]. This method can be used to reset a session manually, and it is not rate limited.
+func (mach *OlmMachine) ResetOlmSession(ctx context.Context, device *id.Device) error {
+	mach.recentlyUnwedgedLock.Lock()
+	mach.recentlyUnwedged[device.IdentityKey] = time.Now()
+	mach.recentlyUnwedgedLock.Unlock()
 	mach.devicesToUnwedgeLock.Lock()
-	mach.devicesToUnwedge[senderKey] = true
+	mach.devicesToUnwedge[device.IdentityKey] = true
 	mach.devicesToUnwedgeLock.Unlock()
-	err = mach.SendEncryptedToDevice(ctx, deviceIdentity, event.ToDeviceDummy, event.Content{})
+	err := mach.SendEncryptedToDevice(ctx, device, event.ToDeviceDummy, event.Content{})
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to send dummy event to unwedge session")
+		return fmt.Errorf("failed to send dummy event: %w", err)
+	}
+	if mach.OlmSessionReset != nil {
+		mach.OlmSessionReset(ctx, device)
 	}
+	return nil
 }
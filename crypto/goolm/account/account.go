@@ -341,7 +341,7 @@ func (a *Account) UnpickleLibOlm(buf []byte) error {
 		return err
 	}
 
-	otkCount, err := decoder.ReadUInt32()
+	otkCount, err := decoder.ReadCount(crypto.OneTimeKeyPickleLength)
 	if err != nil {
 		return err
 	}
@@ -365,3 +365,18 @@ func TestAccountSign(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, verified)
 }
+
+func FuzzAccountUnpickleLibOlm(f *testing.F) {
+	acc, err := account.NewAccount()
+	assert.NoError(f, err)
+	assert.NoError(f, acc.GenOneTimeKeys(2))
+	assert.NoError(f, acc.GenFallbackKey())
+	f.Add(acc.PickleLibOlm())
+	f.Add([]byte{0x00, 0x00, 0x00, 0x04})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var unpickled account.Account
+		if unpickled.UnpickleLibOlm(data) == nil {
+			unpickled.PickleLibOlm()
+		}
+	})
+}
@@ -7,6 +7,9 @@ import (
 	"maunium.net/go/mautrix/crypto/goolm/libolmpickle"
 )
 
+// OneTimeKeyPickleLength is the number of bytes a [OneTimeKey] takes up in a libolm pickle.
+const OneTimeKeyPickleLength = libolmpickle.PickleUInt32Length + libolmpickle.PickleBoolLength + Curve25519PrivateKeyLength + Curve25519PublicKeyLength
+
 // OneTimeKey stores the information about a one time key.
 type OneTimeKey struct {
 	ID        uint32            `json:"id"`
@@ -14,6 +14,8 @@ const pickleMACLength = 8
 var kdfPickle = []byte("Pickle") //used to derive the keys for encryption
 
 // Pickle encrypts the input with the key and the cipher AESSHA256. The result is then encoded in base64.
+//
+// This is the same envelope libolm uses for pickles, so the output can be read by libolm with the same pickle key.
 func Pickle(key, plaintext []byte) ([]byte, error) {
 	if c, err := aessha2.NewAESSHA2(key, kdfPickle); err != nil {
 		return nil, err
@@ -32,6 +34,9 @@ func Unpickle(key, input []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(ciphertext) < pickleMACLength {
+		return nil, fmt.Errorf("decrypt pickle: %w", olm.ErrInputToSmall)
+	}
 	ciphertext, mac := ciphertext[:len(ciphertext)-pickleMACLength], ciphertext[len(ciphertext)-pickleMACLength:]
 	if c, err := aessha2.NewAESSHA2(key, kdfPickle); err != nil {
 		return nil, err
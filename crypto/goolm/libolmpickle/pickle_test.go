@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/crypto/olm"
 )
 
 func TestEncoding(t *testing.T) {
@@ -24,3 +26,22 @@ func TestEncoding(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, toEncrypt, decoded)
 }
+
+func TestUnpickleLibOlmEnvelope(t *testing.T) {
+	// An Olm session pickled by libolm with the key "secret_key"
+	pickledByLibOlm := []byte("icDKYm0b4aO23WgUuOxdpPoxC0UlEOYPVeuduNH3IkpFsmnWx5KuEOpxGiZw5IuB/sSn2RZUCTiJ90IvgC7AClkYGHep9O8lpiqQX73XVKD9okZDCAkBc83eEq0DKYC7HBkGRAU/4T6QPIBBY3UK4QZwULLE/fLsi3j4YZBehMtnlsqgHK0q1bvX4cRznZItVKR4ro0O9EAk6LLxJtSnRu5elSUk7YXT")
+	key := []byte("secret_key")
+	decrypted, err := Unpickle(key, pickledByLibOlm)
+	assert.NoError(t, err)
+	// The first 4 bytes are the libolm session pickle version
+	assert.Equal(t, []byte{0, 0, 0, 1}, decrypted[:4])
+
+	repickled, err := Pickle(key, decrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, pickledByLibOlm, repickled)
+
+	_, err = Unpickle([]byte("wrong_key"), pickledByLibOlm)
+	assert.ErrorIs(t, err, olm.ErrBadMAC)
+	_, err = Unpickle(key, []byte("AAAA"))
+	assert.ErrorIs(t, err, olm.ErrInputToSmall)
+}
@@ -3,9 +3,13 @@ package libolmpickle
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
+// ErrValueTooShort is returned when the pickle ends before all values have been read.
+var ErrValueTooShort = errors.New("value too short")
+
 func isZeroByteSlice(data []byte) bool {
 	for _, b := range data {
 		if b != 0 {
@@ -24,18 +28,25 @@ func NewDecoder(buf []byte) *Decoder {
 }
 
 func (d *Decoder) ReadUInt8() (uint8, error) {
-	return d.buf.ReadByte()
+	val, err := d.buf.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("%w: expected 1 byte for uint8", ErrValueTooShort)
+	}
+	return val, nil
 }
 
 func (d *Decoder) ReadBool() (bool, error) {
 	val, err := d.buf.ReadByte()
-	return val != 0x00, err
+	if err != nil {
+		return false, fmt.Errorf("%w: expected 1 byte for bool", ErrValueTooShort)
+	}
+	return val != 0x00, nil
 }
 
 func (d *Decoder) ReadBytes(length int) (data []byte, err error) {
 	data = d.buf.Next(length)
 	if len(data) != length {
-		return nil, fmt.Errorf("only %d in buffer, expected %d", len(data), length)
+		return nil, fmt.Errorf("%w: only %d in buffer, expected %d", ErrValueTooShort, len(data), length)
 	} else if isZeroByteSlice(data) {
 		return nil, nil
 	}
@@ -45,8 +56,22 @@ func (d *Decoder) ReadBytes(length int) (data []byte, err error) {
 func (d *Decoder) ReadUInt32() (uint32, error) {
 	data := d.buf.Next(4)
 	if len(data) != 4 {
-		return 0, fmt.Errorf("only %d bytes is buffer, expected 4 for uint32", len(data))
+		return 0, fmt.Errorf("%w: only %d bytes is buffer, expected 4 for uint32", ErrValueTooShort, len(data))
 	} else {
 		return binary.BigEndian.Uint32(data), nil
 	}
 }
+
+// ReadCount reads a uint32 list length and checks that the rest of the buffer
+// is long enough to contain that many elements of the given size.
+//
+// This prevents allocating huge slices based on the length in a corrupted pickle.
+func (d *Decoder) ReadCount(elementLength int) (uint32, error) {
+	count, err := d.ReadUInt32()
+	if err != nil {
+		return 0, err
+	} else if uint64(count)*uint64(elementLength) > uint64(d.buf.Len()) {
+		return 0, fmt.Errorf("%w: %d bytes left in buffer, not enough for %d items", ErrValueTooShort, d.buf.Len(), count)
+	}
+	return count, nil
+}
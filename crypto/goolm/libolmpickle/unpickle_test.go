@@ -81,3 +81,30 @@ func TestUnpickleBytes(t *testing.T) {
 		assert.Equal(t, expected[curIndex], response)
 	}
 }
+
+func TestUnpickleTooShort(t *testing.T) {
+	decoder := libolmpickle.NewDecoder([]byte{0x00, 0x01})
+	_, err := decoder.ReadUInt32()
+	assert.ErrorIs(t, err, libolmpickle.ErrValueTooShort)
+	_, err = libolmpickle.NewDecoder(nil).ReadUInt8()
+	assert.ErrorIs(t, err, libolmpickle.ErrValueTooShort)
+	_, err = libolmpickle.NewDecoder(nil).ReadBool()
+	assert.ErrorIs(t, err, libolmpickle.ErrValueTooShort)
+	_, err = libolmpickle.NewDecoder([]byte{0x01}).ReadBytes(2)
+	assert.ErrorIs(t, err, libolmpickle.ErrValueTooShort)
+}
+
+func TestUnpickleCount(t *testing.T) {
+	decoder := libolmpickle.NewDecoder([]byte{0x00, 0x00, 0x00, 0x02, 0xaa, 0xbb, 0xcc, 0xdd})
+	count, err := decoder.ReadCount(2)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	decoder = libolmpickle.NewDecoder([]byte{0x00, 0x00, 0x00, 0x03, 0xaa, 0xbb, 0xcc, 0xdd})
+	_, err = decoder.ReadCount(2)
+	assert.ErrorIs(t, err, libolmpickle.ErrValueTooShort)
+
+	decoder = libolmpickle.NewDecoder([]byte{0xff, 0xff, 0xff, 0xff})
+	_, err = decoder.ReadCount(68)
+	assert.ErrorIs(t, err, libolmpickle.ErrValueTooShort)
+}
@@ -93,3 +93,15 @@ func TestDecryptionPickling(t *testing.T) {
 	assert.EqualValues(t, alicePublic, newDecription.PublicKey(), "public key not correct")
 	assert.EqualValues(t, alicePrivate, newDecription.PrivateKey(), "private key not correct")
 }
+
+func FuzzDecryptionUnpickleLibOlm(f *testing.F) {
+	decryption, err := pk.NewDecryption()
+	assert.NoError(f, err)
+	f.Add(decryption.PickleLibOlm())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var unpickled pk.Decryption
+		if unpickled.UnpickleLibOlm(data) == nil {
+			unpickled.PickleLibOlm()
+		}
+	})
+}
@@ -3,6 +3,7 @@ package ratchet
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/subtle"
 
 	"maunium.net/go/mautrix/crypto/goolm/crypto"
 	"maunium.net/go/mautrix/crypto/goolm/libolmpickle"
@@ -11,6 +12,11 @@ import (
 const (
 	chainKeySeed     = 0x02
 	messageKeyLength = 32
+
+	chainKeyPickleLength          = crypto.Curve25519PublicKeyLength + libolmpickle.PickleUInt32Length
+	receiverChainPickleLength     = crypto.Curve25519PublicKeyLength + chainKeyPickleLength
+	messageKeyPickleLength        = messageKeyLength + libolmpickle.PickleUInt32Length
+	skippedMessageKeyPickleLength = crypto.Curve25519PublicKeyLength + messageKeyPickleLength
 )
 
 // chainKey wraps the index and the public key
@@ -21,12 +27,22 @@ type chainKey struct {
 
 // advance advances the chain
 func (c *chainKey) advance() {
-	hash := hmac.New(sha256.New, c.Key)
-	hash.Write([]byte{chainKeySeed})
-	c.Key = hash.Sum(nil)
+	c.Key = hmacSHA256Seed(c.Key, chainKeySeed)
 	c.Index++
 }
 
+// hmacSHA256Seed computes HMAC-SHA256 of a single seed byte with the given key.
+func hmacSHA256Seed(key []byte, seed byte) []byte {
+	hash := hmac.New(sha256.New, key)
+	hash.Write([]byte{seed})
+	return hash.Sum(nil)
+}
+
+// Equal returns true if both chain keys have the same index and key. The key is compared in constant time.
+func (c chainKey) Equal(other chainKey) bool {
+	return c.Index == other.Index && c.Key.Equal(other.Key)
+}
+
 // UnpickleLibOlm unpickles the unencryted value and populates the chain key accordingly.
 func (r *chainKey) UnpickleLibOlm(decoder *libolmpickle.Decoder) error {
 	err := r.Key.UnpickleLibOlm(decoder)
@@ -77,6 +93,14 @@ func (s senderChain) chainKey() chainKey {
 	return s.CKey
 }
 
+// Equal returns true if both sender chains are equal.
+func (s senderChain) Equal(other senderChain) bool {
+	return s.IsSet == other.IsSet &&
+		s.RKey.PrivateKey.Equal(other.RKey.PrivateKey) &&
+		s.RKey.PublicKey.Equal(other.RKey.PublicKey) &&
+		s.CKey.Equal(other.CKey)
+}
+
 // UnpickleLibOlm unpickles the unencryted value and populates the sender chain
 // accordingly.
 func (r *senderChain) UnpickleLibOlm(decoder *libolmpickle.Decoder) error {
@@ -129,6 +153,11 @@ func (s receiverChain) chainKey() chainKey {
 	return s.CKey
 }
 
+// Equal returns true if both receiver chains are equal.
+func (s receiverChain) Equal(other receiverChain) bool {
+	return s.RKey.Equal(other.RKey) && s.CKey.Equal(other.CKey)
+}
+
 // UnpickleLibOlm unpickles the unencryted value and populates the chain accordingly.
 func (r *receiverChain) UnpickleLibOlm(decoder *libolmpickle.Decoder) error {
 	if err := r.RKey.UnpickleLibOlm(decoder); err != nil {
@@ -149,6 +178,11 @@ type messageKey struct {
 	Key   []byte `json:"key"`
 }
 
+// Equal returns true if both message keys have the same index and key. The key is compared in constant time.
+func (m messageKey) Equal(other messageKey) bool {
+	return m.Index == other.Index && subtle.ConstantTimeCompare(m.Key, other.Key) == 1
+}
+
 // UnpickleLibOlm unpickles the unencryted value and populates the message key
 // accordingly.
 func (m *messageKey) UnpickleLibOlm(decoder *libolmpickle.Decoder) (err error) {
@@ -0,0 +1,13 @@
+package ratchet
+
+import (
+	"testing"
+)
+
+func BenchmarkChainKeyAdvance(b *testing.B) {
+	chain := chainKey{Key: make([]byte, 32)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		chain.advance()
+	}
+}
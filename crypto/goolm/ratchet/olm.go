@@ -2,10 +2,10 @@
 package ratchet
 
 import (
-	"crypto/hmac"
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"slices"
 
 	"golang.org/x/crypto/hkdf"
 
@@ -70,6 +70,43 @@ func New() *Ratchet {
 	return &Ratchet{}
 }
 
+// Equal returns true if both ratchets have the same root key, chains and skipped message keys.
+// Keys are compared in constant time. The order of skipped message keys doesn't matter, but the
+// order of receiver chains does.
+//
+// This is primarily intended for tests and for verifying migrations between pickle formats.
+func (r *Ratchet) Equal(other *Ratchet) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	if !r.RootKey.Equal(other.RootKey) ||
+		!r.SenderChains.Equal(other.SenderChains) ||
+		len(r.ReceiverChains) != len(other.ReceiverChains) ||
+		len(r.SkippedMessageKeys) != len(other.SkippedMessageKeys) {
+		return false
+	}
+	for i := range r.ReceiverChains {
+		if !r.ReceiverChains[i].Equal(other.ReceiverChains[i]) {
+			return false
+		}
+	}
+	matched := make([]bool, len(other.SkippedMessageKeys))
+	for _, key := range r.SkippedMessageKeys {
+		found := false
+		for j, otherKey := range other.SkippedMessageKeys {
+			if !matched[j] && key.Equal(otherKey) {
+				matched[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 // InitializeAsBob initializes this ratchet from a receiving point of view (only first message).
 func (r *Ratchet) InitializeAsBob(sharedSecret []byte, theirRatchetKey crypto.Curve25519PublicKey) error {
 	derivedSecretsReader := hkdf.New(sha256.New, sharedSecret, nil, KdfInfo.Root)
@@ -161,35 +198,38 @@ func (r *Ratchet) Decrypt(input []byte) ([]byte, error) {
 		// No need to advance the chain
 		// Chain already advanced beyond the key for this message
 		// Check if the message keys are in the skipped key list.
-		for curSkippedIndex := range r.SkippedMessageKeys {
-			if message.Counter != r.SkippedMessageKeys[curSkippedIndex].MKey.Index {
-				continue
-			}
-
-			// Found the key for this message. Check the MAC.
-			if cipher, err := aessha2.NewAESSHA2(r.SkippedMessageKeys[curSkippedIndex].MKey.Key, olmKeysKDFInfo); err != nil {
-				return nil, err
-			} else if verified, err := message.VerifyMACInline(r.SkippedMessageKeys[curSkippedIndex].MKey.Key, cipher, input); err != nil {
-				return nil, err
-			} else if !verified {
-				return nil, fmt.Errorf("decrypt from skipped message keys: %w", olm.ErrBadMAC)
-			} else if result, err := cipher.Decrypt(message.Ciphertext); err != nil {
-				return nil, fmt.Errorf("cipher decrypt: %w", err)
-			} else if len(result) != 0 {
-				// Remove the key from the skipped keys now that we've
-				// decoded the message it corresponds to.
-				r.SkippedMessageKeys[curSkippedIndex] = r.SkippedMessageKeys[len(r.SkippedMessageKeys)-1]
-				r.SkippedMessageKeys = r.SkippedMessageKeys[:len(r.SkippedMessageKeys)-1]
-				return result, nil
-			}
+		idx := r.findSkippedKey(message.RatchetKey, message.Counter)
+		if idx < 0 {
+			return nil, fmt.Errorf("decrypt: %w", olm.ErrMessageKeyNotFound)
 		}
-		return nil, fmt.Errorf("decrypt: %w", olm.ErrMessageKeyNotFound)
+		result, err := r.decryptWithSkippedKey(r.SkippedMessageKeys[idx].MKey, message, input)
+		if err != nil {
+			return nil, err
+		}
+		// Only remove the key after a successful decryption, so that forged messages can't discard or reorder keys.
+		r.SkippedMessageKeys = slices.Delete(r.SkippedMessageKeys, idx, idx+1)
+		return result, nil
 	} else {
 		//Advancing the chain is done in this method
 		return r.decryptForExistingChain(receiverChainFromMessage, message, input)
 	}
 }
 
+// decryptWithSkippedKey verifies the MAC of the rawMessage and decrypts it using a previously skipped message key.
+func (r *Ratchet) decryptWithSkippedKey(key messageKey, message *message.Message, rawMessage []byte) ([]byte, error) {
+	if cipher, err := aessha2.NewAESSHA2(key.Key, olmKeysKDFInfo); err != nil {
+		return nil, err
+	} else if verified, err := message.VerifyMACInline(key.Key, cipher, rawMessage); err != nil {
+		return nil, err
+	} else if !verified {
+		return nil, fmt.Errorf("decrypt from skipped message keys: %w", olm.ErrBadMAC)
+	} else if result, err := cipher.Decrypt(message.Ciphertext); err != nil {
+		return nil, fmt.Errorf("cipher decrypt: %w", err)
+	} else {
+		return result, nil
+	}
+}
+
 // advanceRootKey created the next root key and returns the next chainKey
 func (r *Ratchet) advanceRootKey(newRatchetKey crypto.Curve25519KeyPair, oldRatchetKey crypto.Curve25519PublicKey) (crypto.Curve25519PublicKey, error) {
 	sharedSecret, err := newRatchetKey.SharedSecret(oldRatchetKey)
@@ -207,10 +247,8 @@ func (r *Ratchet) advanceRootKey(newRatchetKey crypto.Curve25519KeyPair, oldRatc
 
 // createMessageKeys returns the messageKey derived from the chainKey
 func (r Ratchet) createMessageKeys(chainKey chainKey) messageKey {
-	hash := hmac.New(sha256.New, chainKey.Key)
-	hash.Write([]byte{messageKeySeed})
 	return messageKey{
-		Key:   hash.Sum(nil),
+		Key:   hmacSHA256Seed(chainKey.Key, messageKeySeed),
 		Index: chainKey.Index,
 	}
 }
@@ -313,7 +351,7 @@ func (r *Ratchet) UnpickleLibOlm(decoder *libolmpickle.Decoder, includesChainInd
 		}
 	}
 
-	receiverChainCount, err := decoder.ReadUInt32()
+	receiverChainCount, err := decoder.ReadCount(receiverChainPickleLength)
 	if err != nil {
 		return err
 	}
@@ -324,7 +362,7 @@ func (r *Ratchet) UnpickleLibOlm(decoder *libolmpickle.Decoder, includesChainInd
 		}
 	}
 
-	skippedMessageKeysCount, err := decoder.ReadUInt32()
+	skippedMessageKeysCount, err := decoder.ReadCount(skippedMessageKeyPickleLength)
 	if err != nil {
 		return err
 	}
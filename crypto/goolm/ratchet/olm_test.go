@@ -2,12 +2,15 @@ package ratchet_test
 
 import (
 	"encoding/json"
+	"slices"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"maunium.net/go/mautrix/crypto/goolm/crypto"
+	"maunium.net/go/mautrix/crypto/goolm/libolmpickle"
 	"maunium.net/go/mautrix/crypto/goolm/ratchet"
+	"maunium.net/go/mautrix/crypto/olm"
 )
 
 var (
@@ -79,6 +82,56 @@ func TestOutOfOrder(t *testing.T) {
 	assert.Equal(t, plainText2, decrypted2)
 }
 
+func TestOutOfOrderSkippedKeys(t *testing.T) {
+	aliceRatchet, bobRatchet, err := initializeRatchets()
+	assert.NoError(t, err)
+
+	plainTexts := [][]byte{[]byte("Message 1"), []byte("Message 2"), []byte("Message 3")}
+	encrypted := make([][]byte, len(plainTexts))
+	for i, plainText := range plainTexts {
+		encrypted[i], err = aliceRatchet.Encrypt(plainText)
+		assert.NoError(t, err)
+	}
+
+	for _, i := range []int{2, 0, 1} {
+		decrypted, err := bobRatchet.Decrypt(encrypted[i])
+		assert.NoError(t, err)
+		assert.Equal(t, plainTexts[i], decrypted)
+	}
+	assert.Empty(t, bobRatchet.SkippedMessageKeys)
+
+	// The skipped keys are consumed, so messages can't be decrypted twice
+	_, err = bobRatchet.Decrypt(encrypted[0])
+	assert.ErrorIs(t, err, olm.ErrMessageKeyNotFound)
+}
+
+func TestSkippedKeyKeptOnBadMAC(t *testing.T) {
+	aliceRatchet, bobRatchet, err := initializeRatchets()
+	assert.NoError(t, err)
+
+	message1, err := aliceRatchet.Encrypt([]byte("Message 1"))
+	assert.NoError(t, err)
+	_, err = aliceRatchet.Encrypt([]byte("Message 2"))
+	assert.NoError(t, err)
+	message3, err := aliceRatchet.Encrypt([]byte("Message 3"))
+	assert.NoError(t, err)
+	_, err = bobRatchet.Decrypt(message3)
+	assert.NoError(t, err)
+	assert.Len(t, bobRatchet.SkippedMessageKeys, 2)
+	skippedKeys := slices.Clone(bobRatchet.SkippedMessageKeys)
+
+	// A forged message must not remove or reorder the skipped keys
+	tampered := slices.Clone(message1)
+	tampered[len(tampered)-1] ^= 0xff
+	_, err = bobRatchet.Decrypt(tampered)
+	assert.ErrorIs(t, err, olm.ErrBadMAC)
+	assert.Equal(t, skippedKeys, bobRatchet.SkippedMessageKeys)
+
+	decrypted, err := bobRatchet.Decrypt(message1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Message 1"), decrypted)
+}
+
 func TestMoreMessages(t *testing.T) {
 	aliceRatchet, bobRatchet, err := initializeRatchets()
 	assert.NoError(t, err)
@@ -124,3 +177,39 @@ func TestJSONEncoding(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, plainText, decrypted)
 }
+
+func TestRatchetEqual(t *testing.T) {
+	aliceRatchet, bobRatchet, err := initializeRatchets()
+	assert.NoError(t, err)
+
+	// Skip a message so that bob has a receiver chain and a skipped message key
+	_, err = aliceRatchet.Encrypt([]byte("Skipped"))
+	assert.NoError(t, err)
+	messageEncrypted, err := aliceRatchet.Encrypt([]byte("Received"))
+	assert.NoError(t, err)
+	_, err = bobRatchet.Decrypt(messageEncrypted)
+	assert.NoError(t, err)
+	assert.Len(t, bobRatchet.SkippedMessageKeys, 1)
+
+	encoder := libolmpickle.NewEncoder()
+	bobRatchet.PickleLibOlm(encoder)
+	unpickled := ratchet.New()
+	assert.NoError(t, unpickled.UnpickleLibOlm(libolmpickle.NewDecoder(encoder.Bytes()), false))
+	assert.True(t, bobRatchet.Equal(unpickled))
+	assert.True(t, unpickled.Equal(bobRatchet))
+
+	marshaled, err := json.Marshal(bobRatchet)
+	assert.NoError(t, err)
+	fromJSON := ratchet.New()
+	assert.NoError(t, json.Unmarshal(marshaled, fromJSON))
+	assert.True(t, bobRatchet.Equal(fromJSON))
+
+	assert.False(t, bobRatchet.Equal(aliceRatchet))
+	assert.False(t, bobRatchet.Equal(nil))
+
+	messageEncrypted, err = aliceRatchet.Encrypt([]byte("Another"))
+	assert.NoError(t, err)
+	_, err = unpickled.Decrypt(messageEncrypted)
+	assert.NoError(t, err)
+	assert.False(t, bobRatchet.Equal(unpickled))
+}
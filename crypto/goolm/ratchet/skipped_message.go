@@ -1,6 +1,8 @@
 package ratchet
 
 import (
+	"slices"
+
 	"maunium.net/go/mautrix/crypto/goolm/crypto"
 	"maunium.net/go/mautrix/crypto/goolm/libolmpickle"
 )
@@ -11,6 +13,11 @@ type skippedMessageKey struct {
 	MKey messageKey                 `json:"message_key"`
 }
 
+// Equal returns true if both skipped message keys are equal.
+func (r skippedMessageKey) Equal(other skippedMessageKey) bool {
+	return r.RKey.Equal(other.RKey) && r.MKey.Equal(other.MKey)
+}
+
 // UnpickleLibOlm unpickles the unencryted value and populates the skipped
 // message keys accordingly.
 func (r *skippedMessageKey) UnpickleLibOlm(decoder *libolmpickle.Decoder) (err error) {
@@ -25,3 +32,11 @@ func (r skippedMessageKey) PickleLibOlm(encoder *libolmpickle.Encoder) {
 	r.RKey.PickleLibOlm(encoder)
 	r.MKey.PickleLibOlm(encoder)
 }
+
+// findSkippedKey returns the index of the stored message key for the given ratchet key and message index in
+// the skipped message keys, or -1 if it's not found.
+func (r *Ratchet) findSkippedKey(ratchetKey crypto.Curve25519PublicKey, index uint32) int {
+	return slices.IndexFunc(r.SkippedMessageKeys, func(skipped skippedMessageKey) bool {
+		return skipped.MKey.Index == index && skipped.RKey.Equal(ratchetKey)
+	})
+}
@@ -182,3 +182,31 @@ func TestInbountPickle(t *testing.T) {
 	_, err = session.MegolmInboundSessionFromPickled(pickledDataFromLibOlm, pickleKey)
 	assert.ErrorIs(t, err, base64.CorruptInputError(416))
 }
+
+func FuzzMegolmOutboundSessionUnpickleLibOlm(f *testing.F) {
+	sess, err := session.NewMegolmOutboundSession()
+	assert.NoError(f, err)
+	f.Add(sess.PickleLibOlm())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var unpickled session.MegolmOutboundSession
+		if unpickled.UnpickleLibOlm(data) == nil {
+			unpickled.PickleLibOlm()
+		}
+	})
+}
+
+func FuzzMegolmInboundSessionUnpickleLibOlm(f *testing.F) {
+	outbound, err := session.NewMegolmOutboundSession()
+	assert.NoError(f, err)
+	sessionKey, err := outbound.SessionSharingMessage()
+	assert.NoError(f, err)
+	sess, err := session.NewMegolmInboundSession(sessionKey)
+	assert.NoError(f, err)
+	f.Add(sess.PickleLibOlm())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var unpickled session.MegolmInboundSession
+		if unpickled.UnpickleLibOlm(data) == nil {
+			unpickled.PickleLibOlm()
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package session_test
 
 import (
+	"bytes"
 	"encoding/base64"
 	"testing"
 
@@ -121,3 +122,120 @@ func TestDecrypts(t *testing.T) {
 		assert.ErrorIs(t, err, expectedErr[curIndex])
 	}
 }
+
+func TestSessionPickleLibOlmWithSkippedKeys(t *testing.T) {
+	aliceKeyPair, err := crypto.Curve25519GenerateKey()
+	assert.NoError(t, err)
+	bobKeyPair, err := crypto.Curve25519GenerateKey()
+	assert.NoError(t, err)
+	bobOneTimeKey, err := crypto.Curve25519GenerateKey()
+	assert.NoError(t, err)
+	aliceSession, err := session.NewOutboundOlmSession(aliceKeyPair, bobKeyPair.PublicKey, bobOneTimeKey.PublicKey)
+	assert.NoError(t, err)
+	msgType, message, err := aliceSession.Encrypt([]byte("hello"))
+	assert.NoError(t, err)
+	searchFunc := func(target crypto.Curve25519PublicKey) *crypto.OneTimeKey {
+		if target.Equal(bobOneTimeKey.PublicKey) {
+			return &crypto.OneTimeKey{Key: bobOneTimeKey, ID: 1}
+		}
+		return nil
+	}
+	bobSession, err := session.NewInboundOlmSession(nil, message, searchFunc, bobKeyPair)
+	assert.NoError(t, err)
+	_, err = bobSession.Decrypt(string(message), msgType)
+	assert.NoError(t, err)
+
+	// Bob sends three messages, Alice only receives the last one, so she has a receiver chain and two skipped keys
+	var messages [][]byte
+	for _, plaintext := range []string{"one", "two", "three"} {
+		_, message, err = bobSession.Encrypt([]byte(plaintext))
+		assert.NoError(t, err)
+		messages = append(messages, message)
+	}
+	decrypted, err := aliceSession.Decrypt(string(messages[2]), id.OlmMsgTypeMsg)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("three"), decrypted)
+	assert.Len(t, aliceSession.Ratchet.ReceiverChains, 1)
+	assert.Len(t, aliceSession.Ratchet.SkippedMessageKeys, 2)
+
+	pickled := aliceSession.PickleLibOlm()
+	restored := session.NewOlmSession()
+	assert.NoError(t, restored.UnpickleLibOlm(pickled))
+	assert.Equal(t, pickled, restored.PickleLibOlm())
+
+	decrypted, err = restored.Decrypt(string(messages[0]), id.OlmMsgTypeMsg)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("one"), decrypted)
+	decrypted, err = restored.Decrypt(string(messages[1]), id.OlmMsgTypeMsg)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("two"), decrypted)
+}
+
+func TestSessionUnpickleLibOlmReceiverChains(t *testing.T) {
+	key := func(b byte) []byte {
+		return bytes.Repeat([]byte{b}, 32)
+	}
+	// An unencrypted session pickle laid out by hand following libolm's session.cpp and ratchet.cpp,
+	// with a sender chain, a receiver chain and two skipped message keys. All integers are big-endian.
+	pickled := bytes.Join([][]byte{
+		{0, 0, 0, 1}, // pickle version
+		{1},          // received message
+		key(0x11),    // alice identity key
+		key(0x12),    // alice base key
+		key(0x13),    // bob one-time key
+		key(0x21),    // root key
+		{0, 0, 0, 1}, // sender chain count
+		key(0x31),    // sender ratchet public key
+		key(0x32),    // sender ratchet private key
+		key(0x33),    // sender chain key
+		{0, 0, 0, 5}, // sender chain index
+		{0, 0, 0, 1}, // receiver chain count
+		key(0x41),    // receiver ratchet public key
+		key(0x42),    // receiver chain key
+		{0, 0, 0, 3}, // receiver chain index
+		{0, 0, 0, 2}, // skipped message key count
+		key(0x41),    // skipped message ratchet public key
+		key(0x51),    // skipped message key
+		{0, 0, 0, 0}, // skipped message index
+		key(0x41),    // skipped message ratchet public key
+		key(0x52),    // skipped message key
+		{0, 0, 0, 1}, // skipped message index
+	}, nil)
+
+	sess := session.NewOlmSession()
+	assert.NoError(t, sess.UnpickleLibOlm(pickled))
+	assert.True(t, sess.ReceivedMessage)
+	assert.Equal(t, key(0x11), []byte(sess.AliceIdentityKey))
+	assert.Equal(t, key(0x32), []byte(sess.Ratchet.SenderChains.RKey.PrivateKey))
+	assert.EqualValues(t, 5, sess.Ratchet.SenderChains.CKey.Index)
+	if assert.Len(t, sess.Ratchet.ReceiverChains, 1) {
+		assert.Equal(t, key(0x41), []byte(sess.Ratchet.ReceiverChains[0].RKey))
+		assert.EqualValues(t, 3, sess.Ratchet.ReceiverChains[0].CKey.Index)
+	}
+	if assert.Len(t, sess.Ratchet.SkippedMessageKeys, 2) {
+		assert.Equal(t, key(0x52), sess.Ratchet.SkippedMessageKeys[1].MKey.Key)
+		assert.EqualValues(t, 1, sess.Ratchet.SkippedMessageKeys[1].MKey.Index)
+	}
+	assert.Equal(t, pickled, sess.PickleLibOlm())
+}
+
+func FuzzOlmSessionUnpickleLibOlm(f *testing.F) {
+	aliceKeyPair, err := crypto.Curve25519GenerateKey()
+	assert.NoError(f, err)
+	bobKeyPair, err := crypto.Curve25519GenerateKey()
+	assert.NoError(f, err)
+	bobOneTimeKey, err := crypto.Curve25519GenerateKey()
+	assert.NoError(f, err)
+	sess, err := session.NewOutboundOlmSession(aliceKeyPair, bobKeyPair.PublicKey, bobOneTimeKey.PublicKey)
+	assert.NoError(f, err)
+	f.Add(sess.PickleLibOlm())
+	_, _, err = sess.Encrypt([]byte("fuzz"))
+	assert.NoError(f, err)
+	f.Add(sess.PickleLibOlm())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var unpickled session.OlmSession
+		if unpickled.UnpickleLibOlm(data) == nil {
+			unpickled.PickleLibOlm()
+		}
+	})
+}
@@ -3,20 +3,117 @@ package crypto
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"slices"
 	"time"
 
 	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+	"golang.org/x/crypto/curve25519"
 
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto/backup"
 	"maunium.net/go/mautrix/crypto/olm"
 	"maunium.net/go/mautrix/crypto/signatures"
+	"maunium.net/go/mautrix/crypto/ssss"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
 
+// keyBackupRequestContext returns a context for a single key backup request, applying KeyBackupRequestTimeout if set.
+func (mach *OlmMachine) keyBackupRequestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if mach.KeyBackupRequestTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, mach.KeyBackupRequestTimeout)
+}
+
+// KeyBackupRetryPolicy configures how failed key backup downloads are retried.
+//
+// These retries are separate from the ones configured with [mautrix.Client.DefaultHTTPRetries], so clients that
+// already retry at the HTTP client level should usually leave this disabled to avoid multiplying attempt counts.
+type KeyBackupRetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one. Zero or one disables retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. The delay is doubled after each retry.
+	// If the server specifies retry_after_ms in a rate limit error, that is used instead.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum delay between retries.
+	MaxBackoff time.Duration
+}
+
+// DefaultKeyBackupRetryPolicy is a reasonable retry policy for clients that don't retry requests otherwise.
+// It's not enabled by default, but can be set in [OlmMachine.KeyBackupRetryPolicy]. When MaxAttempts is set,
+// the backoff fields that are zero are also replaced with the values from this policy.
+var DefaultKeyBackupRetryPolicy = KeyBackupRetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 2 * time.Second,
+	MaxBackoff:     30 * time.Second,
+}
+
+func (policy KeyBackupRetryPolicy) withDefaults() KeyBackupRetryPolicy {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = DefaultKeyBackupRetryPolicy.InitialBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = DefaultKeyBackupRetryPolicy.MaxBackoff
+	}
+	return policy
+}
+
+// isRetryableKeyBackupError returns true for errors that are likely to be transient,
+// i.e. network errors, request timeouts, rate limits and server errors.
+func isRetryableKeyBackupError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	} else if httpErr.Response == nil {
+		return httpErr.WrappedError != nil && !errors.Is(httpErr.WrappedError, context.Canceled)
+	}
+	return httpErr.Response.StatusCode == http.StatusTooManyRequests || httpErr.Response.StatusCode >= 500
+}
+
+// retryKeyBackupRequest calls the given function until it succeeds, fails with a permanent error,
+// or the attempts in [OlmMachine.KeyBackupRetryPolicy] run out.
+func (mach *OlmMachine) retryKeyBackupRequest(ctx context.Context, fn func(ctx context.Context) error) error {
+	policy := mach.KeyBackupRetryPolicy.withDefaults()
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		reqCtx, cancel := mach.keyBackupRequestContext(ctx)
+		err := fn(reqCtx)
+		cancel()
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil || !isRetryableKeyBackupError(err) {
+			return err
+		}
+		delay := backoff
+		var respErr mautrix.RespError
+		if errors.As(err, &respErr) {
+			if retryAfter, ok := respErr.RetryAfter(); ok {
+				delay = retryAfter
+			}
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Int("attempt", attempt).
+			Stringer("retry_in", delay).
+			Msg("Key backup request failed, retrying")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, policy.MaxBackoff)
+	}
+}
+
 func (mach *OlmMachine) DownloadAndStoreLatestKeyBackup(ctx context.Context, megolmBackupKey *backup.MegolmBackupKey) (id.KeyBackupVersion, error) {
 	log := mach.machOrContextLog(ctx).With().
 		Str("action", "download and store latest key backup").
@@ -35,14 +132,115 @@ func (mach *OlmMachine) DownloadAndStoreLatestKeyBackup(ctx context.Context, meg
 	return versionInfo.Version, err
 }
 
+// GetMegolmBackupKeyFromSSSS fetches the m.megolm_backup.v1 secret from secret storage and decrypts it using the given key.
+func (mach *OlmMachine) GetMegolmBackupKeyFromSSSS(ctx context.Context, key *ssss.Key) (*backup.MegolmBackupKey, error) {
+	keyData, err := mach.SSSS.GetDecryptedAccountData(ctx, event.AccountDataMegolmBackupKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get megolm backup key from SSSS: %w", err)
+	}
+	megolmBackupKey, err := backup.MegolmBackupKeyFromBytes(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse megolm backup key from SSSS: %w", err)
+	}
+	return megolmBackupKey, nil
+}
+
+// DownloadAndStoreLatestKeyBackupWithSSSS fetches the megolm backup key from secret storage,
+// then verifies and imports the latest key backup version using it.
+//
+// This is meant to be called right after the user has unlocked secret storage (e.g. with their recovery key),
+// so that old messages become decryptable without having to enter the backup key separately.
+func (mach *OlmMachine) DownloadAndStoreLatestKeyBackupWithSSSS(ctx context.Context, key *ssss.Key) (*backup.MegolmBackupKey, id.KeyBackupVersion, error) {
+	megolmBackupKey, err := mach.GetMegolmBackupKeyFromSSSS(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	version, err := mach.DownloadAndStoreLatestKeyBackup(ctx, megolmBackupKey)
+	return megolmBackupKey, version, err
+}
+
 func (mach *OlmMachine) GetAndVerifyLatestKeyBackupVersion(ctx context.Context, megolmBackupKey *backup.MegolmBackupKey) (*mautrix.RespRoomKeysVersion[backup.MegolmAuthData], error) {
-	versionInfo, err := mach.Client.GetKeyBackupLatestVersion(ctx)
+	reqCtx, cancel := mach.keyBackupRequestContext(ctx)
+	versionInfo, err := mach.Client.GetKeyBackupLatestVersion(reqCtx)
+	cancel()
 	if err != nil {
 		return nil, err
 	}
 
-	if versionInfo.Algorithm != id.KeyBackupAlgorithmMegolmBackupV1 {
+	report, err := mach.verifyKeyBackupVersion(ctx, versionInfo, megolmBackupKey, false)
+	if err != nil {
+		return nil, err
+	} else if !report.SupportedAlgorithm {
 		return nil, fmt.Errorf("unsupported key backup algorithm: %s", versionInfo.Algorithm)
+	} else if report.Trusted {
+		return versionInfo, nil
+	} else if len(report.Signatures) == 0 {
+		return nil, fmt.Errorf("no signature from user %s found in key backup", mach.Client.UserID)
+	} else if report.CrossSigningKeysMissing {
+		return nil, ErrCrossSigningPubkeysNotCached
+	} else {
+		return nil, fmt.Errorf("no valid signature from user %s found in key backup", mach.Client.UserID)
+	}
+}
+
+// BackupVerificationReport describes why a key backup version is or isn't trusted.
+type BackupVerificationReport struct {
+	Version   id.KeyBackupVersion
+	Algorithm id.KeyBackupAlgorithm
+	// SupportedAlgorithm is false if the backup doesn't use m.megolm_backup.v1.curve25519-aes-sha2.
+	SupportedAlgorithm bool
+	// DerivedKeyMatches is true if the public key derived from the given backup key matches the backup's auth data.
+	DerivedKeyMatches bool
+	// CrossSigningKeysMissing is true if our own cross-signing public keys weren't available,
+	// which means signatures from the master key couldn't be checked.
+	CrossSigningKeysMissing bool
+	// Signatures contains an entry for each ed25519 signature from our own user in the backup's auth data.
+	Signatures []BackupSignatureReport
+	// Trusted is true if the backup is trusted, either based on the derived key or a valid signature.
+	Trusted bool
+}
+
+// BackupSignatureReport describes a single signature on a key backup version.
+type BackupSignatureReport struct {
+	KeyID id.KeyID
+	// IsMasterKey is true if the signature is from our own master cross-signing key.
+	IsMasterKey bool
+	// DeviceMissing is true if the signature is from a device that isn't in the crypto store.
+	DeviceMissing bool
+	// DeviceUntrusted is true if the signature is from a device that isn't trusted.
+	DeviceUntrusted bool
+	// Verified is true if the signature was checked and is valid.
+	Verified bool
+	// Error is set if looking up the device that made the signature failed.
+	Error error
+}
+
+// VerifyKeyBackup fetches the latest key backup version and checks whether it's trusted without importing
+// anything or writing to the crypto store. This is meant for diagnosing key backup trust issues: the returned
+// report explains the same decisions that [OlmMachine.GetAndVerifyLatestKeyBackupVersion] makes.
+func (mach *OlmMachine) VerifyKeyBackup(ctx context.Context, megolmBackupKey *backup.MegolmBackupKey) (*BackupVerificationReport, error) {
+	reqCtx, cancel := mach.keyBackupRequestContext(ctx)
+	versionInfo, err := mach.Client.GetKeyBackupLatestVersion(reqCtx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	return mach.verifyKeyBackupVersion(ctx, versionInfo, megolmBackupKey, true)
+}
+
+// verifyKeyBackupVersion checks whether the given key backup version is trusted.
+//
+// If collectAll is false, this returns as soon as the backup is found to be trusted, and store errors are returned
+// directly. If it's true, every signature is checked and included in the report, and store errors are recorded in
+// the report instead of aborting the whole check.
+func (mach *OlmMachine) verifyKeyBackupVersion(ctx context.Context, versionInfo *mautrix.RespRoomKeysVersion[backup.MegolmAuthData], megolmBackupKey *backup.MegolmBackupKey, collectAll bool) (*BackupVerificationReport, error) {
+	report := &BackupVerificationReport{
+		Version:            versionInfo.Version,
+		Algorithm:          versionInfo.Algorithm,
+		SupportedAlgorithm: versionInfo.Algorithm == id.KeyBackupAlgorithmMegolmBackupV1,
+	}
+	if !report.SupportedAlgorithm {
+		return report, nil
 	}
 
 	log := mach.machOrContextLog(ctx).With().
@@ -56,78 +254,88 @@ func (mach *OlmMachine) GetAndVerifyLatestKeyBackupVersion(ctx context.Context,
 	// ...by deriving the public key from a private key that it obtained from a trusted source. Trusted sources for the private
 	// key include the user entering the key, retrieving the key stored in secret storage, or obtaining the key via secret sharing
 	// from a verified device belonging to the same user."
-	megolmBackupDerivedPublicKey := id.Ed25519(base64.RawStdEncoding.EncodeToString(megolmBackupKey.PublicKey().Bytes()))
-	if megolmBackupKey != nil && versionInfo.AuthData.PublicKey == megolmBackupDerivedPublicKey {
-		log.Debug().Msg("key backup is trusted based on derived public key")
-		return versionInfo, nil
-	} else {
-		log.Debug().
-			Stringer("expected_key", megolmBackupDerivedPublicKey).
-			Stringer("actual_key", versionInfo.AuthData.PublicKey).
-			Msg("key backup public keys do not match, proceeding to check device signatures")
+	if megolmBackupKey != nil {
+		megolmBackupDerivedPublicKey := id.Ed25519(base64.RawStdEncoding.EncodeToString(megolmBackupKey.PublicKey().Bytes()))
+		if versionInfo.AuthData.PublicKey == megolmBackupDerivedPublicKey {
+			log.Debug().Msg("key backup is trusted based on derived public key")
+			report.DerivedKeyMatches = true
+			report.Trusted = true
+			if !collectAll {
+				return report, nil
+			}
+		} else {
+			log.Debug().
+				Stringer("expected_key", megolmBackupDerivedPublicKey).
+				Stringer("actual_key", versionInfo.AuthData.PublicKey).
+				Msg("key backup public keys do not match, proceeding to check device signatures")
+		}
 	}
 
 	// "...or checking that it is signed by the user’s master cross-signing key or by a verified device belonging to the same user"
-	userSignatures, ok := versionInfo.AuthData.Signatures[mach.Client.UserID]
-	if !ok {
-		return nil, fmt.Errorf("no signature from user %s found in key backup", mach.Client.UserID)
-	}
-
 	crossSigningPubkeys := mach.GetOwnCrossSigningPublicKeys(ctx)
-	if crossSigningPubkeys == nil {
-		return nil, ErrCrossSigningPubkeysNotCached
-	}
-
-	signatureVerified := false
-	for keyID := range userSignatures {
+	report.CrossSigningKeysMissing = crossSigningPubkeys == nil
+	for keyID := range versionInfo.AuthData.Signatures[mach.Client.UserID] {
 		keyAlg, keyName := keyID.Parse()
 		if keyAlg != id.KeyAlgorithmEd25519 {
 			continue
 		}
 		log := log.With().Str("key_name", keyName).Logger()
+		sigReport := BackupSignatureReport{KeyID: keyID}
 
 		var key id.Ed25519
-		if keyName == crossSigningPubkeys.MasterKey.String() {
+		if crossSigningPubkeys != nil && keyName == crossSigningPubkeys.MasterKey.String() {
+			sigReport.IsMasterKey = true
 			key = crossSigningPubkeys.MasterKey
 		} else if device, err := mach.CryptoStore.GetDevice(ctx, mach.Client.UserID, id.DeviceID(keyName)); err != nil {
-			return nil, fmt.Errorf("failed to get device %s/%s from store: %w", mach.Client.UserID, keyName, err)
+			err = fmt.Errorf("failed to get device %s/%s from store: %w", mach.Client.UserID, keyName, err)
+			if !collectAll {
+				return nil, err
+			}
+			log.Warn().Err(err).Msg("Failed to get device, ignoring signature")
+			sigReport.Error = err
 		} else if device == nil {
-			log.Warn().Err(err).Msg("Device does not exist, ignoring signature")
-			continue
+			log.Warn().Msg("Device does not exist, ignoring signature")
+			sigReport.DeviceMissing = true
 		} else if !mach.IsDeviceTrusted(ctx, device) {
-			log.Warn().Err(err).Msg("Device is not trusted")
-			continue
+			log.Warn().Msg("Device is not trusted")
+			sigReport.DeviceUntrusted = true
 		} else {
 			key = device.SigningKey
 		}
 
-		ok, err = signatures.VerifySignatureJSON(versionInfo.AuthData, mach.Client.UserID, keyName, key)
-		if err != nil || !ok {
-			log.Warn().Err(err).Stringer("key_id", keyID).Msg("Signature verification failed")
-			continue
-		} else {
-			// One of the signatures is valid, break from the loop.
-			log.Debug().Stringer("key_id", keyID).Msg("key backup is trusted based on matching signature")
-			signatureVerified = true
+		if key != "" {
+			ok, err := signatures.VerifySignatureJSON(versionInfo.AuthData, mach.Client.UserID, keyName, key)
+			if err != nil || !ok {
+				log.Warn().Err(err).Stringer("key_id", keyID).Msg("Signature verification failed")
+			} else {
+				log.Debug().Stringer("key_id", keyID).Msg("key backup is trusted based on matching signature")
+				sigReport.Verified = true
+				report.Trusted = true
+			}
+		}
+		report.Signatures = append(report.Signatures, sigReport)
+		if report.Trusted && !collectAll {
 			break
 		}
 	}
-	if !signatureVerified {
-		return nil, fmt.Errorf("no valid signature from user %s found in key backup", mach.Client.UserID)
-	}
-
-	return versionInfo, nil
+	return report, nil
 }
 
 func (mach *OlmMachine) GetAndStoreKeyBackup(ctx context.Context, version id.KeyBackupVersion, megolmBackupKey *backup.MegolmBackupKey) error {
-	keys, err := mach.Client.GetKeyBackup(ctx, version)
+	var keys *mautrix.RespRoomKeys[backup.EncryptedSessionData[backup.MegolmSessionData]]
+	err := mach.retryKeyBackupRequest(ctx, func(ctx context.Context) (err error) {
+		keys, err = mach.Client.GetKeyBackup(ctx, version)
+		return
+	})
 	if err != nil {
 		return err
 	}
 
 	log := zerolog.Ctx(ctx)
+	ctx, flushReceivedSessions := mach.WithSessionReceivedBatch(ctx)
+	defer flushReceivedSessions()
 
-	var count, failedCount int
+	var count, failedCount, invalidCount int
 
 	for roomID, backup := range keys.Rooms {
 		for sessionID, keyBackupData := range backup.Sessions {
@@ -139,7 +347,14 @@ func (mach *OlmMachine) GetAndStoreKeyBackup(ctx context.Context, version id.Key
 			}
 
 			_, err = mach.ImportRoomKeyFromBackup(ctx, version, roomID, sessionID, sessionData)
-			if err != nil {
+			if errors.Is(err, ErrInvalidSenderKeyInKeyBackup) {
+				log.Warn().Err(err).
+					Stringer("room_id", roomID).
+					Stringer("session_id", sessionID).
+					Msg("Skipping invalid entry in key backup")
+				invalidCount++
+				continue
+			} else if err != nil {
 				log.Warn().Err(err).Msg("Failed to import room key from backup")
 				failedCount++
 				continue
@@ -151,17 +366,186 @@ func (mach *OlmMachine) GetAndStoreKeyBackup(ctx context.Context, version id.Key
 	log.Info().
 		Int("count", count).
 		Int("failed_count", failedCount).
+		Int("invalid_count", invalidCount).
 		Msg("successfully imported sessions from backup")
 
 	return nil
 }
 
+// KeyBackupStore contains the storage methods used by the key backup functions of [OlmMachine].
+// It can be set in [OlmMachine.KeyBackupStore] to store backed up sessions somewhere other than the main crypto store.
+//
+// Implementations should also have a FindDeviceByIdentityKey method like [SQLCryptoStore.FindDeviceByIdentityKey],
+// which is used to check the claimed sender of restored sessions. Without it, all restored sessions are flagged
+// with UnverifiedSource.
+type KeyBackupStore interface {
+	// GetGroupSession gets an inbound Megolm session. A nil session should be returned if it's not found.
+	GetGroupSession(context.Context, id.RoomID, id.SessionID) (*InboundGroupSession, error)
+	// PutGroupSession stores an inbound Megolm session.
+	PutGroupSession(context.Context, *InboundGroupSession) error
+	// GetGroupSessionsWithoutKeyBackupVersion gets all inbound Megolm sessions that aren't in the given key backup version.
+	GetGroupSessionsWithoutKeyBackupVersion(context.Context, id.KeyBackupVersion) dbutil.RowIter[*InboundGroupSession]
+	// MarkGroupSessionsBackedUp sets the key backup version of the given inbound Megolm sessions
+	// without touching any other data of the sessions.
+	MarkGroupSessionsBackedUp(context.Context, id.KeyBackupVersion, []id.SessionID) error
+	// GetEncryptionEvent returns the encryption event's content for an encrypted room.
+	GetEncryptionEvent(context.Context, id.RoomID) (*event.EncryptionEventContent, error)
+}
+
+type defaultKeyBackupStore struct {
+	Store
+	StateStore
+}
+
+var _ KeyBackupStore = defaultKeyBackupStore{}
+
+// FindDeviceByIdentityKey implements identityKeyDeviceFinder if the underlying crypto store supports it.
+func (store defaultKeyBackupStore) FindDeviceByIdentityKey(ctx context.Context, identityKey id.IdentityKey) (*id.Device, error) {
+	finder, ok := store.Store.(identityKeyDeviceFinder)
+	if !ok {
+		return nil, nil
+	}
+	return finder.FindDeviceByIdentityKey(ctx, identityKey)
+}
+
+func (mach *OlmMachine) keyBackupStore() KeyBackupStore {
+	if mach.KeyBackupStore != nil {
+		return mach.KeyBackupStore
+	}
+	return defaultKeyBackupStore{Store: mach.CryptoStore, StateStore: mach.StateStore}
+}
+
+// keyBackupUploadBatchSize is the maximum number of sessions to upload to the key backup in a single request.
+const keyBackupUploadBatchSize = 100
+
+// UploadGroupSessionsToBackup uploads all inbound group sessions that haven't been stored in the given key backup
+// version yet. Sessions are uploaded in batches and marked as backed up in the crypto store after each successful
+// batch, so calling this repeatedly only uploads sessions that are new.
+//
+// New sessions are not uploaded automatically: callers must call this themselves, e.g. from a debounced
+// [OlmMachine.SessionReceived] or [OlmMachine.SessionsReceived] callback, to keep the backup up to date.
+//
+// The caller is responsible for making sure the backup version is trusted before uploading keys to it,
+// e.g. by using [OlmMachine.GetAndVerifyLatestKeyBackupVersion]. If version is empty, this is a no-op.
+func (mach *OlmMachine) UploadGroupSessionsToBackup(ctx context.Context, version id.KeyBackupVersion, megolmBackupKey *backup.MegolmBackupKey) (int, error) {
+	if version == "" || megolmBackupKey == nil {
+		return 0, nil
+	}
+	log := mach.machOrContextLog(ctx).With().
+		Str("action", "upload group sessions to backup").
+		Stringer("key_backup_version", version).
+		Logger()
+	store := mach.keyBackupStore()
+	sessions, err := store.GetGroupSessionsWithoutKeyBackupVersion(ctx, version).AsList()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get sessions to back up: %w", err)
+	} else if len(sessions) == 0 {
+		return 0, nil
+	}
+	var count int
+	for batch := range slices.Chunk(sessions, keyBackupUploadBatchSize) {
+		req := &mautrix.ReqKeyBackup{Rooms: make(map[id.RoomID]mautrix.ReqRoomKeyBackup)}
+		for _, session := range batch {
+			backupData, err := encryptSessionForBackup(session, megolmBackupKey)
+			if err != nil {
+				return count, fmt.Errorf("failed to encrypt session %s for backup: %w", session.ID(), err)
+			}
+			roomBackup, ok := req.Rooms[session.RoomID]
+			if !ok {
+				roomBackup = mautrix.ReqRoomKeyBackup{Sessions: make(map[id.SessionID]mautrix.ReqKeyBackupData)}
+				req.Rooms[session.RoomID] = roomBackup
+			}
+			roomBackup.Sessions[session.ID()] = *backupData
+		}
+		reqCtx, cancel := mach.keyBackupRequestContext(ctx)
+		_, err = mach.Client.PutKeysInBackup(reqCtx, version, req)
+		cancel()
+		if err != nil {
+			return count, fmt.Errorf("failed to upload sessions to backup: %w", err)
+		}
+		sessionIDs := make([]id.SessionID, len(batch))
+		for i, session := range batch {
+			sessionIDs[i] = session.ID()
+		}
+		// Only update the backup version, as the sessions may have been changed in the store during the upload.
+		err = store.MarkGroupSessionsBackedUp(ctx, version, sessionIDs)
+		if err != nil {
+			return count, fmt.Errorf("failed to mark sessions as backed up: %w", err)
+		}
+		count += len(batch)
+		log.Debug().Int("batch_size", len(batch)).Int("total_count", count).Msg("Uploaded batch of sessions to key backup")
+	}
+	log.Info().Int("count", count).Msg("Uploaded sessions to key backup")
+	return count, nil
+}
+
+func encryptSessionForBackup(session *InboundGroupSession, megolmBackupKey *backup.MegolmBackupKey) (*mautrix.ReqKeyBackupData, error) {
+	firstKnownIndex := session.Internal.FirstKnownIndex()
+	sessionKey, err := session.Internal.Export(firstKnownIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export session: %w", err)
+	}
+	encrypted, err := backup.EncryptSessionData(megolmBackupKey, &backup.MegolmSessionData{
+		Algorithm:          id.AlgorithmMegolmV1,
+		ForwardingKeyChain: session.ForwardingChains,
+		SenderClaimedKeys:  backup.SenderClaimedKeys{Ed25519: session.SigningKey},
+		SenderKey:          session.SenderKey,
+		SessionKey:         string(sessionKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	encryptedJSON, err := json.Marshal(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	return &mautrix.ReqKeyBackupData{
+		FirstMessageIndex: int(firstKnownIndex),
+		ForwardedCount:    len(session.ForwardingChains),
+		SessionData:       encryptedJSON,
+	}, nil
+}
+
 var (
+	ErrForwardingChainTooLong                        = errors.New("forwarding chain in key backup is too long")
+	ErrInvalidSenderKeyInKeyBackup                   = errors.New("invalid sender key in key backup")
 	ErrUnknownAlgorithmInKeyBackup                   = errors.New("ignoring room key in backup with weird algorithm")
 	ErrMismatchingSessionIDInKeyBackup               = errors.New("mismatched session ID while creating inbound group session from key backup")
 	ErrFailedToStoreNewInboundGroupSessionFromBackup = errors.New("failed to store new inbound group session from key backup")
 )
 
+// DefaultMaxForwardingChainLength is the default value for [OlmMachine.MaxForwardingChainLength].
+const DefaultMaxForwardingChainLength = 32
+
+// checkForwardingChain validates the forwarding chain of a key backup entry and appends the sender key to it.
+// Chains longer than the configured maximum are rejected, and duplicate entries are removed.
+// The sender key is always the last entry of the returned chain, even if it was already in the chain.
+// The sender key must already have been validated by the caller.
+func (mach *OlmMachine) checkForwardingChain(ctx context.Context, keyBackupData *backup.MegolmSessionData) ([]string, error) {
+	maxLength := mach.MaxForwardingChainLength
+	if maxLength <= 0 {
+		maxLength = DefaultMaxForwardingChainLength
+	}
+	if len(keyBackupData.ForwardingKeyChain) >= maxLength {
+		return nil, fmt.Errorf("%w (%d entries, max %d)", ErrForwardingChainTooLong, len(keyBackupData.ForwardingKeyChain), maxLength)
+	}
+	senderKey := keyBackupData.SenderKey.String()
+	chain := make([]string, 0, len(keyBackupData.ForwardingKeyChain)+1)
+	for _, key := range keyBackupData.ForwardingKeyChain {
+		// The sender key is always added last below, as trust is resolved based on the last entry in the chain.
+		if key != senderKey && !slices.Contains(chain, key) {
+			chain = append(chain, key)
+		}
+	}
+	chain = append(chain, senderKey)
+	if len(chain) != len(keyBackupData.ForwardingKeyChain)+1 {
+		zerolog.Ctx(ctx).Warn().
+			Strs("forwarding_chain", keyBackupData.ForwardingKeyChain).
+			Msg("Removed duplicate entries from forwarding chain in key backup")
+	}
+	return chain, nil
+}
+
 func (mach *OlmMachine) ImportRoomKeyFromBackupWithoutSaving(
 	ctx context.Context,
 	version id.KeyBackupVersion,
@@ -173,6 +557,8 @@ func (mach *OlmMachine) ImportRoomKeyFromBackupWithoutSaving(
 	log := zerolog.Ctx(ctx)
 	if keyBackupData.Algorithm != id.AlgorithmMegolmV1 {
 		return nil, fmt.Errorf("%w %s", ErrUnknownAlgorithmInKeyBackup, keyBackupData.Algorithm)
+	} else if len(keyBackupData.SenderKey.Bytes()) != curve25519.PointSize {
+		return nil, fmt.Errorf("%w %q", ErrInvalidSenderKeyInKeyBackup, keyBackupData.SenderKey)
 	}
 
 	igsInternal, err := olm.InboundGroupSessionImport([]byte(keyBackupData.SessionKey))
@@ -187,6 +573,11 @@ func (mach *OlmMachine) ImportRoomKeyFromBackupWithoutSaving(
 		return nil, ErrMismatchingSessionIDInKeyBackup
 	}
 
+	forwardingChain, err := mach.checkForwardingChain(ctx, keyBackupData)
+	if err != nil {
+		return nil, err
+	}
+
 	var maxAge time.Duration
 	var maxMessages int
 	if config != nil {
@@ -199,18 +590,50 @@ func (mach *OlmMachine) ImportRoomKeyFromBackupWithoutSaving(
 		SigningKey:       keyBackupData.SenderClaimedKeys.Ed25519,
 		SenderKey:        keyBackupData.SenderKey,
 		RoomID:           roomID,
-		ForwardingChains: append(keyBackupData.ForwardingKeyChain, keyBackupData.SenderKey.String()),
+		ForwardingChains: forwardingChain,
 		id:               sessionID,
 
 		ReceivedAt:       time.Now().UTC(),
 		MaxAge:           maxAge.Milliseconds(),
 		MaxMessages:      maxMessages,
 		KeyBackupVersion: version,
+		UnverifiedSource: !mach.isKnownBackupSessionSource(ctx, keyBackupData),
 	}, nil
 }
 
+// identityKeyDeviceFinder is an optional interface for a [KeyBackupStore] that can look up devices by identity key.
+// If the key backup store doesn't implement it, the source of imported sessions can't be confirmed,
+// so all of them are flagged with UnverifiedSource.
+type identityKeyDeviceFinder interface {
+	FindDeviceByIdentityKey(ctx context.Context, identityKey id.IdentityKey) (*id.Device, error)
+}
+
+// isKnownBackupSessionSource checks whether the sender keys claimed by a key backup entry belong to a known device.
+// Key backups aren't authenticated, so anyone who knows the backup public key can upload sessions claiming to be
+// from any device. Sessions that fail this check are flagged with UnverifiedSource, which is only informational
+// and doesn't affect the trust state of decrypted events.
+func (mach *OlmMachine) isKnownBackupSessionSource(ctx context.Context, keyBackupData *backup.MegolmSessionData) bool {
+	finder, ok := mach.keyBackupStore().(identityKeyDeviceFinder)
+	if !ok {
+		return false
+	}
+	device, err := finder.FindDeviceByIdentityKey(ctx, keyBackupData.SenderKey)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Stringer("sender_key", keyBackupData.SenderKey).
+			Msg("Failed to find device to verify key backup session source")
+		return false
+	}
+	return device != nil && device.SigningKey == keyBackupData.SenderClaimedKeys.Ed25519
+}
+
+// ImportRoomKeyFromBackup imports a single decrypted session from the key backup and stores it.
+//
+// If the store already has the same session with an equal or lower first known index, the stored session is kept
+// and returned instead, so that restoring a backup never loses the ability to decrypt older messages.
 func (mach *OlmMachine) ImportRoomKeyFromBackup(ctx context.Context, version id.KeyBackupVersion, roomID id.RoomID, sessionID id.SessionID, keyBackupData *backup.MegolmSessionData) (*InboundGroupSession, error) {
-	config, err := mach.StateStore.GetEncryptionEvent(ctx, roomID)
+	store := mach.keyBackupStore()
+	config, err := store.GetEncryptionEvent(ctx, roomID)
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).
 			Stringer("room_id", roomID).
@@ -222,6 +645,22 @@ func (mach *OlmMachine) ImportRoomKeyFromBackup(ctx context.Context, version id.
 		return nil, err
 	}
 	firstKnownIndex := imported.Internal.FirstKnownIndex()
+	existing, err := store.GetGroupSession(ctx, roomID, sessionID)
+	if err != nil && !errors.Is(err, ErrGroupSessionWithheld) {
+		// Don't risk overwriting a better session if the lookup failed
+		return nil, fmt.Errorf("failed to get existing session: %w", err)
+	} else if existing != nil && existing.Internal.FirstKnownIndex() <= firstKnownIndex {
+		// We already have an equivalent or better session in the store, so don't override it.
+		// If the backup has an equally good copy, mark the existing session as backed up to avoid reuploading it.
+		if existing.Internal.FirstKnownIndex() == firstKnownIndex && existing.KeyBackupVersion != version {
+			existing.KeyBackupVersion = version
+			err = store.PutGroupSession(ctx, existing)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update key backup version of existing session: %w", err)
+			}
+		}
+		return existing, nil
+	}
 	if firstKnownIndex > 0 {
 		zerolog.Ctx(ctx).Warn().
 			Stringer("room_id", roomID).
@@ -229,7 +668,7 @@ func (mach *OlmMachine) ImportRoomKeyFromBackup(ctx context.Context, version id.
 			Uint32("first_known_index", firstKnownIndex).
 			Msg("Importing partial session")
 	}
-	err = mach.CryptoStore.PutGroupSession(ctx, imported)
+	err = store.PutGroupSession(ctx, imported)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrFailedToStoreNewInboundGroupSessionFromBackup, err)
 	}
@@ -0,0 +1,561 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/signatures"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func newBackupTestSession(t *testing.T, mach *OlmMachine, roomID id.RoomID) *InboundGroupSession {
+	outSess, err := mach.newOutboundGroupSession(context.TODO(), roomID)
+	require.NoError(t, err)
+	inSess, err := mach.CryptoStore.GetGroupSession(context.TODO(), roomID, outSess.ID())
+	require.NoError(t, err)
+	require.NotNil(t, inSess)
+	return inSess
+}
+
+func TestEncryptSessionForBackup(t *testing.T) {
+	mach := newMachine(t, "user1")
+	session := newBackupTestSession(t, mach, "room1")
+	backupKey, err := backup.NewMegolmBackupKey()
+	require.NoError(t, err)
+
+	backupData, err := encryptSessionForBackup(session, backupKey)
+	require.NoError(t, err)
+	assert.Equal(t, 0, backupData.FirstMessageIndex)
+
+	var encrypted backup.EncryptedSessionData[backup.MegolmSessionData]
+	require.NoError(t, json.Unmarshal(backupData.SessionData, &encrypted))
+	decrypted, err := encrypted.Decrypt(backupKey)
+	require.NoError(t, err)
+	assert.Equal(t, session.SenderKey, decrypted.SenderKey)
+	assert.Equal(t, session.SigningKey, decrypted.SenderClaimedKeys.Ed25519)
+
+	imported, err := mach.ImportRoomKeyFromBackupWithoutSaving(context.TODO(), "1", "room1", nil, session.ID(), decrypted)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID(), imported.ID())
+	assert.Equal(t, id.KeyBackupVersion("1"), imported.KeyBackupVersion)
+}
+
+func TestUploadGroupSessionsToBackup(t *testing.T) {
+	ctx := context.TODO()
+	var requests atomic.Int32
+	uploaded := make(map[id.SessionID]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/_matrix/client/v3/room_keys/keys", r.URL.Path)
+		assert.Equal(t, "1", r.URL.Query().Get("version"))
+		var req mautrix.ReqKeyBackup
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		var batchSize int
+		for _, room := range req.Rooms {
+			for sessionID := range room.Sessions {
+				uploaded[sessionID]++
+				batchSize++
+			}
+		}
+		assert.LessOrEqual(t, batchSize, keyBackupUploadBatchSize)
+		_, _ = w.Write([]byte(`{"count":0,"etag":"1"}`))
+	}))
+	defer server.Close()
+
+	mach := newMachine(t, "user1")
+	mach.Client.HomeserverURL, _ = mach.Client.HomeserverURL.Parse(server.URL)
+	backupKey, err := backup.NewMegolmBackupKey()
+	require.NoError(t, err)
+
+	sessionCount := keyBackupUploadBatchSize + keyBackupUploadBatchSize/2
+	sessionIDs := make([]id.SessionID, sessionCount)
+	for i := range sessionIDs {
+		roomID := id.RoomID(fmt.Sprintf("!room%d:example.com", i%3))
+		sessionIDs[i] = newBackupTestSession(t, mach, roomID).ID()
+	}
+
+	count, err := mach.UploadGroupSessionsToBackup(ctx, "1", backupKey)
+	require.NoError(t, err)
+	assert.Equal(t, sessionCount, count)
+	assert.EqualValues(t, 2, requests.Load())
+	require.Len(t, uploaded, sessionCount)
+	for i, sessionID := range sessionIDs {
+		assert.Equal(t, 1, uploaded[sessionID])
+		roomID := id.RoomID(fmt.Sprintf("!room%d:example.com", i%3))
+		stored, err := mach.CryptoStore.GetGroupSession(ctx, roomID, sessionID)
+		require.NoError(t, err)
+		assert.Equal(t, id.KeyBackupVersion("1"), stored.KeyBackupVersion)
+	}
+
+	// Everything is already backed up, so nothing should be uploaded again
+	count, err = mach.UploadGroupSessionsToBackup(ctx, "1", backupKey)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.EqualValues(t, 2, requests.Load())
+}
+
+func TestImportRoomKeyFromBackup_UnverifiedSource(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "user1")
+	session := newBackupTestSession(t, mach, "room1")
+	exported, err := session.export()
+	require.NoError(t, err)
+	backupData := &backup.MegolmSessionData{
+		Algorithm:         id.AlgorithmMegolmV1,
+		SenderClaimedKeys: backup.SenderClaimedKeys{Ed25519: session.SigningKey},
+		SenderKey:         session.SenderKey,
+		SessionKey:        exported.SessionKey,
+	}
+
+	imported, err := mach.ImportRoomKeyFromBackupWithoutSaving(ctx, "1", "room1", nil, session.ID(), backupData)
+	require.NoError(t, err)
+	assert.True(t, imported.UnverifiedSource)
+
+	device := &id.Device{
+		UserID:      "user1",
+		DeviceID:    "device1",
+		IdentityKey: mach.account.IdentityKey(),
+		SigningKey:  mach.account.SigningKey(),
+	}
+	require.NoError(t, mach.CryptoStore.PutDevice(ctx, "user1", device))
+	imported, err = mach.ImportRoomKeyFromBackupWithoutSaving(ctx, "1", "room1", nil, session.ID(), backupData)
+	require.NoError(t, err)
+	assert.False(t, imported.UnverifiedSource)
+
+	backupData.SenderClaimedKeys.Ed25519 = "invalid"
+	imported, err = mach.ImportRoomKeyFromBackupWithoutSaving(ctx, "1", "room1", nil, session.ID(), backupData)
+	require.NoError(t, err)
+	assert.True(t, imported.UnverifiedSource)
+
+	// Deleted devices don't confirm the source
+	backupData.SenderClaimedKeys.Ed25519 = session.SigningKey
+	device.Deleted = true
+	require.NoError(t, mach.CryptoStore.PutDevice(ctx, "user1", device))
+	imported, err = mach.ImportRoomKeyFromBackupWithoutSaving(ctx, "1", "room1", nil, session.ID(), backupData)
+	require.NoError(t, err)
+	assert.True(t, imported.UnverifiedSource)
+}
+
+func TestDecryptMegolmEvent_UnverifiedSource(t *testing.T) {
+	ctx := context.TODO()
+	machineOut := newMachine(t, "user1")
+	machineIn := newMachine(t, "user2")
+	machineIn.DisableDecryptKeyFetching = true
+
+	outSess, err := machineOut.newOutboundGroupSession(ctx, "room1")
+	require.NoError(t, err)
+	outSess.Shared = true
+	require.NoError(t, machineOut.CryptoStore.AddOutboundGroupSession(ctx, outSess))
+	inSess, err := machineOut.CryptoStore.GetGroupSession(ctx, "room1", outSess.ID())
+	require.NoError(t, err)
+	exported, err := inSess.export()
+	require.NoError(t, err)
+	encrypted, err := machineOut.EncryptMegolmEvent(ctx, "room1", event.EventMessage, map[string]string{"hello": "world"})
+	require.NoError(t, err)
+
+	// The sender's device isn't known, so the source of the session can't be confirmed
+	_, err = machineIn.ImportRoomKeyFromBackup(ctx, "1", "room1", outSess.ID(), &backup.MegolmSessionData{
+		Algorithm:         id.AlgorithmMegolmV1,
+		SenderClaimedKeys: backup.SenderClaimedKeys{Ed25519: inSess.SigningKey},
+		SenderKey:         inSess.SenderKey,
+		SessionKey:        exported.SessionKey,
+	})
+	require.NoError(t, err)
+
+	decrypted, err := machineIn.DecryptMegolmEvent(ctx, &event.Event{
+		Content: event.Content{Parsed: encrypted},
+		Type:    event.EventEncrypted,
+		ID:      "$event1",
+		RoomID:  "room1",
+		Sender:  "user1",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "world", decrypted.Content.Raw["hello"])
+	assert.True(t, decrypted.Mautrix.UnverifiedSource)
+	assert.False(t, decrypted.Mautrix.ForwardedKeys)
+	assert.Equal(t, id.TrustStateUnknownDevice, decrypted.Mautrix.TrustState)
+}
+
+func TestImportRoomKeyFromBackup_InvalidSenderKey(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "user1")
+	session := newBackupTestSession(t, mach, "room1")
+	exported, err := session.export()
+	require.NoError(t, err)
+	backupData := &backup.MegolmSessionData{
+		Algorithm:         id.AlgorithmMegolmV1,
+		SenderClaimedKeys: backup.SenderClaimedKeys{Ed25519: session.SigningKey},
+		SessionKey:        exported.SessionKey,
+	}
+
+	_, err = mach.ImportRoomKeyFromBackupWithoutSaving(ctx, "1", "room1", nil, session.ID(), backupData)
+	assert.ErrorIs(t, err, ErrInvalidSenderKeyInKeyBackup)
+	backupData.SenderKey = "not a key"
+	_, err = mach.ImportRoomKeyFromBackupWithoutSaving(ctx, "1", "room1", nil, session.ID(), backupData)
+	assert.ErrorIs(t, err, ErrInvalidSenderKeyInKeyBackup)
+
+	backupData.SenderKey = session.SenderKey
+	imported, err := mach.ImportRoomKeyFromBackupWithoutSaving(ctx, "1", "room1", nil, session.ID(), backupData)
+	require.NoError(t, err)
+	assert.Equal(t, []string{session.SenderKey.String()}, imported.ForwardingChains)
+}
+
+func TestImportRoomKeyFromBackup_KeepBetterSession(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "user1")
+	session := newBackupTestSession(t, mach, "room1")
+	fullKey, err := session.Internal.Export(0)
+	require.NoError(t, err)
+	partialKey, err := session.Internal.Export(1)
+	require.NoError(t, err)
+	backupData := func(sessionKey []byte) *backup.MegolmSessionData {
+		return &backup.MegolmSessionData{
+			Algorithm:         id.AlgorithmMegolmV1,
+			SenderClaimedKeys: backup.SenderClaimedKeys{Ed25519: session.SigningKey},
+			SenderKey:         session.SenderKey,
+			SessionKey:        string(sessionKey),
+		}
+	}
+
+	partial, err := mach.ImportRoomKeyFromBackupWithoutSaving(ctx, "", "room1", nil, session.ID(), backupData(partialKey))
+	require.NoError(t, err)
+	require.NoError(t, mach.CryptoStore.PutGroupSession(ctx, partial))
+
+	imported, err := mach.ImportRoomKeyFromBackup(ctx, "1", "room1", session.ID(), backupData(fullKey))
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, imported.Internal.FirstKnownIndex())
+
+	imported, err = mach.ImportRoomKeyFromBackup(ctx, "2", "room1", session.ID(), backupData(partialKey))
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, imported.Internal.FirstKnownIndex())
+	stored, err := mach.CryptoStore.GetGroupSession(ctx, "room1", session.ID())
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, stored.Internal.FirstKnownIndex())
+	assert.Equal(t, id.KeyBackupVersion("1"), stored.KeyBackupVersion)
+
+	_, err = mach.ImportRoomKeyFromBackup(ctx, "2", "room1", session.ID(), backupData(fullKey))
+	require.NoError(t, err)
+	stored, err = mach.CryptoStore.GetGroupSession(ctx, "room1", session.ID())
+	require.NoError(t, err)
+	assert.Equal(t, id.KeyBackupVersion("2"), stored.KeyBackupVersion)
+}
+
+type testKeyBackupStore struct {
+	*MemoryStore
+	mockStateStore
+}
+
+func TestImportRoomKeyFromBackup_CustomStore(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "user1")
+	customStore := testKeyBackupStore{MemoryStore: NewMemoryStore(nil)}
+	mach.KeyBackupStore = customStore
+	session := newBackupTestSession(t, mach, "room2")
+	exported, err := session.export()
+	require.NoError(t, err)
+
+	imported, err := mach.ImportRoomKeyFromBackup(ctx, "1", "room1", session.ID(), &backup.MegolmSessionData{
+		Algorithm:         id.AlgorithmMegolmV1,
+		SenderClaimedKeys: backup.SenderClaimedKeys{Ed25519: session.SigningKey},
+		SenderKey:         session.SenderKey,
+		SessionKey:        exported.SessionKey,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, imported.MaxMessages)
+	stored, err := customStore.GetGroupSession(ctx, "room1", session.ID())
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	stored, err = mach.CryptoStore.GetGroupSession(ctx, "room1", session.ID())
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	// Devices are looked up from the custom store too
+	require.NoError(t, customStore.PutDevice(ctx, "user2", &id.Device{
+		UserID:      "user2",
+		DeviceID:    "device2",
+		IdentityKey: session.SenderKey,
+		SigningKey:  "other",
+	}))
+	imported, err = mach.ImportRoomKeyFromBackupWithoutSaving(ctx, "1", "room1", nil, session.ID(), &backup.MegolmSessionData{
+		Algorithm:         id.AlgorithmMegolmV1,
+		SenderClaimedKeys: backup.SenderClaimedKeys{Ed25519: session.SigningKey},
+		SenderKey:         session.SenderKey,
+		SessionKey:        exported.SessionKey,
+	})
+	require.NoError(t, err)
+	assert.True(t, imported.UnverifiedSource)
+}
+
+type failingGroupSessionStore struct {
+	testKeyBackupStore
+}
+
+func (failingGroupSessionStore) GetGroupSession(context.Context, id.RoomID, id.SessionID) (*InboundGroupSession, error) {
+	return nil, fmt.Errorf("database is on fire")
+}
+
+func TestImportRoomKeyFromBackup_StoreError(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "user1")
+	session := newBackupTestSession(t, mach, "room1")
+	exported, err := session.export()
+	require.NoError(t, err)
+	backupData := &backup.MegolmSessionData{
+		Algorithm:         id.AlgorithmMegolmV1,
+		SenderClaimedKeys: backup.SenderClaimedKeys{Ed25519: session.SigningKey},
+		SenderKey:         session.SenderKey,
+		SessionKey:        exported.SessionKey,
+	}
+
+	// Withheld sessions are replaced by the backup copy
+	require.NoError(t, mach.CryptoStore.PutWithheldGroupSession(ctx, event.RoomKeyWithheldEventContent{
+		RoomID:    "room2",
+		Algorithm: id.AlgorithmMegolmV1,
+		SessionID: session.ID(),
+		SenderKey: session.SenderKey,
+		Code:      event.RoomKeyWithheldUnavailable,
+	}))
+	imported, err := mach.ImportRoomKeyFromBackup(ctx, "1", "room2", session.ID(), backupData)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, imported.Internal.FirstKnownIndex())
+
+	// Other errors must not cause the stored session to be overwritten
+	customStore := failingGroupSessionStore{testKeyBackupStore{MemoryStore: NewMemoryStore(nil)}}
+	mach.KeyBackupStore = customStore
+	_, err = mach.ImportRoomKeyFromBackup(ctx, "1", "room1", session.ID(), backupData)
+	assert.ErrorContains(t, err, "database is on fire")
+	assert.Empty(t, customStore.GroupSessions["room1"])
+}
+
+func TestCheckForwardingChain(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "user1")
+	mach.MaxForwardingChainLength = 5
+
+	chain, err := mach.checkForwardingChain(ctx, &backup.MegolmSessionData{
+		SenderKey: "sender",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sender"}, chain)
+
+	chain, err = mach.checkForwardingChain(ctx, &backup.MegolmSessionData{
+		SenderKey:          "sender",
+		ForwardingKeyChain: []string{"a", "b", "a", "sender"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "sender"}, chain)
+
+	// The sender key must always be last, even if the backup has it earlier in the chain
+	chain, err = mach.checkForwardingChain(ctx, &backup.MegolmSessionData{
+		SenderKey:          "sender",
+		ForwardingKeyChain: []string{"sender", "x"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "sender"}, chain)
+
+	pathological := make([]string, 100000)
+	for i := range pathological {
+		pathological[i] = "a"
+	}
+	_, err = mach.checkForwardingChain(ctx, &backup.MegolmSessionData{
+		SenderKey:          "sender",
+		ForwardingKeyChain: pathological,
+	})
+	assert.ErrorIs(t, err, ErrForwardingChainTooLong)
+
+	session := newBackupTestSession(t, mach, "room1")
+	exported, err := session.export()
+	require.NoError(t, err)
+	_, err = mach.ImportRoomKeyFromBackupWithoutSaving(ctx, "1", "room1", nil, session.ID(), &backup.MegolmSessionData{
+		Algorithm:          id.AlgorithmMegolmV1,
+		ForwardingKeyChain: pathological,
+		SenderClaimedKeys:  backup.SenderClaimedKeys{Ed25519: session.SigningKey},
+		SenderKey:          session.SenderKey,
+		SessionKey:         exported.SessionKey,
+	})
+	assert.ErrorIs(t, err, ErrForwardingChainTooLong)
+}
+
+func TestWithSessionReceivedBatch(t *testing.T) {
+	mach := newMachine(t, "user1")
+	var single int
+	var batches [][]ReceivedSessionInfo
+	mach.SessionReceived = func(context.Context, id.RoomID, id.SessionID, uint32) {
+		single++
+	}
+	mach.SessionsReceived = func(_ context.Context, sessions []ReceivedSessionInfo) {
+		batches = append(batches, sessions)
+	}
+
+	ctx, flush := mach.WithSessionReceivedBatch(context.TODO())
+	mach.MarkSessionReceived(ctx, "room1", "session1", 0)
+	mach.MarkSessionReceived(ctx, "room2", "session2", 5)
+	assert.Equal(t, 0, single)
+	assert.Empty(t, batches)
+	flush()
+	assert.Equal(t, [][]ReceivedSessionInfo{{
+		{RoomID: "room1", SessionID: "session1"},
+		{RoomID: "room2", SessionID: "session2", FirstKnownIndex: 5},
+	}}, batches)
+
+	mach.MarkSessionReceived(context.TODO(), "room3", "session3", 0)
+	assert.Equal(t, 1, single)
+}
+
+func TestVerifyKeyBackupVersion(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "user1")
+	mach.crossSigningPubkeysFetched = true
+	backupKey, err := backup.NewMegolmBackupKey()
+	require.NoError(t, err)
+	otherKey, err := backup.NewMegolmBackupKey()
+	require.NoError(t, err)
+
+	versionInfo := &mautrix.RespRoomKeysVersion[backup.MegolmAuthData]{
+		Algorithm: id.KeyBackupAlgorithmMegolmBackupV1,
+		AuthData: backup.MegolmAuthData{
+			PublicKey:  id.Ed25519(base64.RawStdEncoding.EncodeToString(backupKey.PublicKey().Bytes())),
+			Signatures: signatures.NewSingleSignature("user1", id.KeyAlgorithmEd25519, "OTHERDEVICE", "invalid"),
+		},
+		Version: "1",
+	}
+
+	report, err := mach.verifyKeyBackupVersion(ctx, versionInfo, backupKey, true)
+	require.NoError(t, err)
+	assert.True(t, report.DerivedKeyMatches)
+	assert.True(t, report.Trusted)
+	assert.True(t, report.CrossSigningKeysMissing)
+	require.Len(t, report.Signatures, 1)
+	assert.True(t, report.Signatures[0].DeviceMissing)
+	assert.False(t, report.Signatures[0].Verified)
+
+	// Without collectAll, a matching derived key is enough and signatures aren't checked at all.
+	report, err = mach.verifyKeyBackupVersion(ctx, versionInfo, backupKey, false)
+	require.NoError(t, err)
+	assert.True(t, report.Trusted)
+	assert.Empty(t, report.Signatures)
+
+	report, err = mach.verifyKeyBackupVersion(ctx, versionInfo, otherKey, true)
+	require.NoError(t, err)
+	assert.False(t, report.DerivedKeyMatches)
+	assert.False(t, report.Trusted)
+
+	versionInfo.Algorithm = "m.unknown"
+	report, err = mach.verifyKeyBackupVersion(ctx, versionInfo, backupKey, true)
+	require.NoError(t, err)
+	assert.False(t, report.SupportedAlgorithm)
+	assert.False(t, report.Trusted)
+}
+
+func TestKeyBackupRequestTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	mach := newMachine(t, "user1")
+	mach.Client.HomeserverURL, _ = mach.Client.HomeserverURL.Parse(server.URL)
+	mach.KeyBackupRequestTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, err := mach.VerifyKeyBackup(context.TODO(), nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// A zero timeout returns the parent context as-is
+	mach.KeyBackupRequestTimeout = 0
+	parent, cancelParent := context.WithCancel(context.TODO())
+	defer cancelParent()
+	ctx, cancel := mach.keyBackupRequestContext(parent)
+	cancel()
+	assert.Equal(t, parent, ctx)
+	assert.NoError(t, ctx.Err())
+	_, hasDeadline := ctx.Deadline()
+	assert.False(t, hasDeadline)
+}
+
+func TestGetAndStoreKeyBackup_Retry(t *testing.T) {
+	var attempts atomic.Int32
+	var failures atomic.Int32
+	var failStatus atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(int(failStatus.Load()))
+			_, _ = w.Write([]byte(`{"errcode":"M_UNKNOWN","error":"flaky","retry_after_ms":1}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"rooms":{}}`))
+	}))
+	defer server.Close()
+
+	mach := newMachine(t, "user1")
+	mach.Client.HomeserverURL, _ = mach.Client.HomeserverURL.Parse(server.URL)
+	mach.KeyBackupRetryPolicy = KeyBackupRetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	backupKey, err := backup.NewMegolmBackupKey()
+	require.NoError(t, err)
+
+	failures.Store(2)
+	failStatus.Store(http.StatusBadGateway)
+	err = mach.GetAndStoreKeyBackup(context.TODO(), "1", backupKey)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, attempts.Load())
+
+	attempts.Store(0)
+	failures.Store(3)
+	err = mach.GetAndStoreKeyBackup(context.TODO(), "1", backupKey)
+	assert.Error(t, err)
+	assert.EqualValues(t, 3, attempts.Load())
+
+	attempts.Store(0)
+	failures.Store(1)
+	failStatus.Store(http.StatusNotFound)
+	err = mach.GetAndStoreKeyBackup(context.TODO(), "1", backupKey)
+	assert.Error(t, err)
+	assert.EqualValues(t, 1, attempts.Load())
+
+	// retry_after_ms from the server takes precedence over the backoff in the policy
+	mach.KeyBackupRetryPolicy = KeyBackupRetryPolicy{MaxAttempts: 2, InitialBackoff: time.Hour}
+	attempts.Store(0)
+	failures.Store(1)
+	failStatus.Store(http.StatusTooManyRequests)
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+	err = mach.GetAndStoreKeyBackup(ctx, "1", backupKey)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, attempts.Load())
+
+	// retries are disabled by default
+	mach.KeyBackupRetryPolicy = KeyBackupRetryPolicy{}
+	attempts.Store(0)
+	failures.Store(1)
+	failStatus.Store(http.StatusBadGateway)
+	err = mach.GetAndStoreKeyBackup(context.TODO(), "1", backupKey)
+	assert.Error(t, err)
+	assert.EqualValues(t, 1, attempts.Load())
+}
@@ -8,6 +8,7 @@ package crypto
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
@@ -18,6 +19,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 
 	"go.mau.fi/util/dbutil"
@@ -104,16 +106,16 @@ func exportSessionsJSON(sessions []*InboundGroupSession) ([]byte, error) {
 	return json.Marshal(exportedSessions)
 }
 
-func formatKeyExportData(data []byte) []byte {
+func formatKeyExportData(prefix, suffix string, data []byte) []byte {
 	encodedLen := base64.StdEncoding.EncodedLen(len(data))
-	outputLength := len(exportPrefix) +
+	outputLength := len(prefix) +
 		encodedLen + int(math.Ceil(float64(encodedLen)/exportLineLengthLimit)) +
-		len(exportSuffix)
+		len(suffix)
 	output := make([]byte, 0, outputLength)
 	outputWriter := (*exbytes.Writer)(&output)
 	base64Writer := base64.NewEncoder(base64.StdEncoding, outputWriter)
 	lineByteCount := base64.StdEncoding.DecodedLen(exportLineLengthLimit)
-	exerrors.Must(outputWriter.WriteString(exportPrefix))
+	exerrors.Must(outputWriter.WriteString(prefix))
 	for i := 0; i < len(data); i += lineByteCount {
 		exerrors.Must(base64Writer.Write(data[i:min(i+lineByteCount, len(data))]))
 		if i+lineByteCount >= len(data) {
@@ -121,7 +123,7 @@ func formatKeyExportData(data []byte) []byte {
 		}
 		exerrors.PanicIfNotNil(outputWriter.WriteByte('\n'))
 	}
-	exerrors.Must(outputWriter.WriteString(exportSuffix))
+	exerrors.Must(outputWriter.WriteString(suffix))
 	if len(output) != outputLength {
 		panic(fmt.Errorf("unexpected length %d / %d", len(output), outputLength))
 	}
@@ -169,7 +171,22 @@ func ExportKeys(passphrase string, sessions []*InboundGroupSession) ([]byte, err
 	return EncryptKeyExport(passphrase, unencryptedData)
 }
 
+// ExportRoomKeys exports all inbound Megolm sessions in the crypto store using the standard Matrix key export
+// format, which can be imported by other clients like Element.
+func (mach *OlmMachine) ExportRoomKeys(ctx context.Context, passphrase string) (io.Reader, error) {
+	data, err := ExportKeysIter(passphrase, mach.CryptoStore.GetAllGroupSessions(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
 func EncryptKeyExport(passphrase string, unencryptedData json.RawMessage) ([]byte, error) {
+	// Format the export (prefix, base64'd exportData, suffix) and return
+	return formatKeyExportData(exportPrefix, exportSuffix, encryptExportData(passphrase, unencryptedData)), nil
+}
+
+func encryptExportData(passphrase string, unencryptedData []byte) []byte {
 	// Make all the keys necessary for exporting
 	encryptionKey, hashKey, salt, iv := makeExportKeys(passphrase)
 
@@ -197,8 +214,5 @@ func EncryptKeyExport(passphrase string, unencryptedData json.RawMessage) ([]byt
 	// Hash all the data with HMAC-SHA256 and put it at the end
 	mac := hmac.New(sha256.New, hashKey)
 	mac.Write(exportData[:dataWithoutHashLength])
-	mac.Sum(exportData[:dataWithoutHashLength])
-
-	// Format the export (prefix, base64'd exportData, suffix) and return
-	return formatKeyExportData(exportData), nil
+	return mac.Sum(exportData[:dataWithoutHashLength])
 }
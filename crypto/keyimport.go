@@ -18,6 +18,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"maunium.net/go/mautrix/crypto/olm"
@@ -27,27 +29,29 @@ import (
 var (
 	ErrMissingExportPrefix          = errors.New("invalid Matrix key export: missing prefix")
 	ErrMissingExportSuffix          = errors.New("invalid Matrix key export: missing suffix")
+	ErrExportTooShort               = errors.New("invalid Matrix key export: data too short")
 	ErrUnsupportedExportVersion     = errors.New("unsupported Matrix key export format version")
 	ErrMismatchingExportHash        = errors.New("mismatching hash; incorrect passphrase?")
 	ErrInvalidExportedAlgorithm     = errors.New("session has unknown algorithm")
 	ErrMismatchingExportedSessionID = errors.New("imported session has different ID than expected")
 )
 
-var exportPrefixBytes, exportSuffixBytes = []byte(exportPrefix), []byte(exportSuffix)
-
-func decodeKeyExport(data []byte) ([]byte, error) {
+func decodeKeyExport(data []byte, prefix, suffix string) ([]byte, error) {
 	// Fix some types of corruption in the key export file before checking anything
 	if bytes.IndexByte(data, '\r') != -1 {
 		data = bytes.ReplaceAll(data, []byte{'\r', '\n'}, []byte{'\n'})
 	}
+	// Allow any number of newlines at the end, e.g. the test vectors in Element don't have one after the suffix
+	data = bytes.TrimRight(data, "\n")
+	suffix = strings.TrimRight(suffix, "\n")
 	// If the valid prefix and suffix aren't there, it's probably not a Matrix key export
-	if !bytes.HasPrefix(data, exportPrefixBytes) {
+	if !bytes.HasPrefix(data, []byte(prefix)) {
 		return nil, ErrMissingExportPrefix
-	} else if !bytes.HasSuffix(data, exportSuffixBytes) {
+	} else if !bytes.HasSuffix(data, []byte(suffix)) {
 		return nil, ErrMissingExportSuffix
 	}
 	// Remove the prefix and suffix, we don't care about them anymore
-	data = data[len(exportPrefix) : len(data)-len(exportSuffix)]
+	data = data[len(prefix) : len(data)-len(suffix)]
 
 	// Allocate space for the decoded data. Ignore newlines when counting the length
 	exportData := make([]byte, base64.StdEncoding.DecodedLen(len(data)-bytes.Count(data, []byte{'\n'})))
@@ -60,7 +64,24 @@ func decodeKeyExport(data []byte) ([]byte, error) {
 }
 
 func decryptKeyExport(passphrase string, exportData []byte) ([]ExportedSession, error) {
-	if exportData[0] != exportVersion1 {
+	unencryptedData, err := decryptExportData(passphrase, exportData)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the decrypted JSON
+	var sessionsJSON []ExportedSession
+	err = json.Unmarshal(unencryptedData, &sessionsJSON)
+	if err != nil {
+		return nil, fmt.Errorf("invalid export json: %w", err)
+	}
+	return sessionsJSON, nil
+}
+
+func decryptExportData(passphrase string, exportData []byte) ([]byte, error) {
+	if len(exportData) < exportHeaderLength+exportHashLength {
+		return nil, ErrExportTooShort
+	} else if exportData[0] != exportVersion1 {
 		return nil, ErrUnsupportedExportVersion
 	}
 
@@ -86,14 +107,7 @@ func decryptKeyExport(passphrase string, exportData []byte) ([]ExportedSession,
 	block, _ := aes.NewCipher(encryptionKey)
 	unencryptedData := make([]byte, len(exportData)-exportHashLength-exportHeaderLength)
 	cipher.NewCTR(block, iv).XORKeyStream(unencryptedData, encryptedData)
-
-	// Parse the decrypted JSON
-	var sessionsJSON []ExportedSession
-	err := json.Unmarshal(unencryptedData, &sessionsJSON)
-	if err != nil {
-		return nil, fmt.Errorf("invalid export json: %w", err)
-	}
-	return sessionsJSON, nil
+	return unencryptedData, nil
 }
 
 func (mach *OlmMachine) importExportedRoomKey(ctx context.Context, session ExportedSession) (bool, error) {
@@ -134,7 +148,7 @@ func (mach *OlmMachine) importExportedRoomKey(ctx context.Context, session Expor
 // ImportKeys imports data that was exported with the format specified in the Matrix spec.
 // See https://spec.matrix.org/v1.2/client-server-api/#key-exports
 func (mach *OlmMachine) ImportKeys(ctx context.Context, passphrase string, data []byte) (int, int, error) {
-	exportData, err := decodeKeyExport(data)
+	exportData, err := decodeKeyExport(data, exportPrefix, exportSuffix)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -143,6 +157,8 @@ func (mach *OlmMachine) ImportKeys(ctx context.Context, passphrase string, data
 		return 0, 0, err
 	}
 
+	ctx, flushReceivedSessions := mach.WithSessionReceivedBatch(ctx)
+	defer flushReceivedSessions()
 	count := 0
 	for _, session := range sessions {
 		log := mach.Log.With().
@@ -164,3 +180,13 @@ func (mach *OlmMachine) ImportKeys(ctx context.Context, passphrase string, data
 	}
 	return count, len(sessions), nil
 }
+
+// ImportRoomKeys reads a file in the standard Matrix key export format (e.g. one exported from Element)
+// and imports the sessions in it. The return values are the same as in [OlmMachine.ImportKeys].
+func (mach *OlmMachine) ImportRoomKeys(ctx context.Context, r io.Reader, passphrase string) (int, int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read key export: %w", err)
+	}
+	return mach.ImportKeys(ctx, passphrase, data)
+}
@@ -8,6 +8,7 @@ import "maunium.net/go/mautrix/crypto/olm"
 var pickleKey = []byte("maunium.net/go/mautrix/crypto/olm")
 
 func init() {
+	olm.Driver = "libolm"
 	olm.GetVersion = func() (major, minor, patch uint8) {
 		C.olm_get_library_version(
 			(*C.uint8_t)(&major),
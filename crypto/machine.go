@@ -51,6 +51,19 @@ type OlmMachine struct {
 
 	AllowKeyShare func(context.Context, *id.Device, event.RequestedKeyInfo) *KeyShareRejection
 
+	// KeyBackupRequestTimeout is the timeout for individual key backup HTTP requests made by the machine.
+	// The context passed to the key backup methods can still cancel the whole operation. Zero means no timeout.
+	KeyBackupRequestTimeout time.Duration
+	// MaxForwardingChainLength is the maximum number of entries allowed in the forwarding chain of
+	// sessions imported from key backup. Zero means DefaultMaxForwardingChainLength.
+	MaxForwardingChainLength int
+	// KeyBackupRetryPolicy configures retries for transient errors when downloading the key backup.
+	// Retries are disabled by default, see DefaultKeyBackupRetryPolicy for a reasonable policy.
+	KeyBackupRetryPolicy KeyBackupRetryPolicy
+	// KeyBackupStore is an optional alternative store used by the key backup methods for Megolm sessions and
+	// room encryption settings. If nil, CryptoStore and StateStore are used.
+	KeyBackupStore KeyBackupStore
+
 	account *OlmAccount
 
 	roomKeyRequestFilled            *sync.Map
@@ -61,6 +74,11 @@ type OlmMachine struct {
 
 	// Optional callback which is called when we save a session to store
 	SessionReceived func(context.Context, id.RoomID, id.SessionID, uint32)
+	// Optional callback which is called with all sessions received during a bulk import (e.g. key backup restore).
+	// If not set, SessionReceived is called for each session at the end of the import instead.
+	SessionsReceived func(context.Context, []ReceivedSessionInfo)
+	// Optional callback which is called after a new Olm session is created to recover from a wedged session.
+	OlmSessionReset func(context.Context, *id.Device)
 
 	devicesToUnwedge     map[id.IdentityKey]bool
 	devicesToUnwedgeLock sync.Mutex
@@ -595,8 +613,50 @@ func (mach *OlmMachine) createGroupSession(ctx context.Context, senderKey id.Sen
 	return nil
 }
 
+// ReceivedSessionInfo contains the info passed to the SessionReceived callback.
+type ReceivedSessionInfo struct {
+	RoomID          id.RoomID
+	SessionID       id.SessionID
+	FirstKnownIndex uint32
+}
+
+type sessionReceivedBatch struct {
+	lock     sync.Mutex
+	sessions []ReceivedSessionInfo
+}
+
+type sessionReceivedBatchKey struct{}
+
+// WithSessionReceivedBatch returns a context that makes MarkSessionReceived collect sessions instead of
+// calling the SessionReceived callback immediately. The returned function must be called after the bulk
+// operation is done to deliver the collected sessions to SessionsReceived (or SessionReceived if unset).
+//
+// Goroutines waiting for sessions with WaitForSession are still woken up immediately.
+func (mach *OlmMachine) WithSessionReceivedBatch(ctx context.Context) (context.Context, func()) {
+	batch := &sessionReceivedBatch{}
+	return context.WithValue(ctx, sessionReceivedBatchKey{}, batch), func() {
+		batch.lock.Lock()
+		sessions := batch.sessions
+		batch.sessions = nil
+		batch.lock.Unlock()
+		if len(sessions) == 0 {
+			return
+		} else if mach.SessionsReceived != nil {
+			mach.SessionsReceived(ctx, sessions)
+		} else if mach.SessionReceived != nil {
+			for _, sess := range sessions {
+				mach.SessionReceived(ctx, sess.RoomID, sess.SessionID, sess.FirstKnownIndex)
+			}
+		}
+	}
+}
+
 func (mach *OlmMachine) MarkSessionReceived(ctx context.Context, roomID id.RoomID, id id.SessionID, firstKnownIndex uint32) {
-	if mach.SessionReceived != nil {
+	if batch, ok := ctx.Value(sessionReceivedBatchKey{}).(*sessionReceivedBatch); ok {
+		batch.lock.Lock()
+		batch.sessions = append(batch.sessions, ReceivedSessionInfo{RoomID: roomID, SessionID: id, FirstKnownIndex: firstKnownIndex})
+		batch.lock.Unlock()
+	} else if mach.SessionReceived != nil {
 		mach.SessionReceived(ctx, roomID, id, firstKnownIndex)
 	}
 
@@ -8,7 +8,12 @@ package crypto
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -169,8 +174,69 @@ func TestOlmMachineOlmMegolmSessions(t *testing.T) {
 	if megolmOutSession.Expired() {
 		t.Error("Megolm outbound session expired before 3rd message")
 	}
+	if remaining := megolmOutSession.RemainingMessages(); remaining != 1 {
+		t.Errorf("Expected 1 remaining message before rotation, got %d", remaining)
+	}
 	machineOut.EncryptMegolmEvent(context.TODO(), "room1", event.EventMessage, eventContent)
 	if !megolmOutSession.Expired() {
 		t.Error("Megolm outbound session not expired after 3rd message")
 	}
+	if remaining := megolmOutSession.RemainingMessages(); remaining != 0 {
+		t.Errorf("Expected no remaining messages after 3rd message, got %d", remaining)
+	}
+}
+
+func TestOutboundGroupSessionExpiresAt(t *testing.T) {
+	ogs, err := NewOutboundGroupSession("room1", &event.EncryptionEventContent{RotationPeriodMillis: 2 * 60 * 60 * 1000})
+	require.NoError(t, err)
+	assert.Equal(t, ogs.CreationTime.Add(2*time.Hour), ogs.ExpiresAt())
+	ogs.MaxAge = 0
+	assert.True(t, ogs.ExpiresAt().IsZero())
+}
+
+func TestResetOlmSession(t *testing.T) {
+	machineOut := newMachine(t, "user1")
+	machineIn := newMachine(t, "user2")
+	otks := machineIn.account.getOneTimeKeys("user2", "device1", 0)
+	var sentToDevice int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/keys/claim"):
+			for keyID, otk := range otks {
+				delete(otks, keyID)
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"one_time_keys": map[id.UserID]map[id.DeviceID]map[id.KeyID]mautrix.OneTimeKey{
+						"user2": {"device1": {keyID: otk}},
+					},
+				})
+				return
+			}
+		case strings.Contains(r.URL.Path, "/sendToDevice/"):
+			sentToDevice++
+			_, _ = w.Write([]byte("{}"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	machineOut.Client.HomeserverURL, _ = machineOut.Client.HomeserverURL.Parse(server.URL)
+	device := &id.Device{
+		UserID:      "user2",
+		DeviceID:    "device1",
+		IdentityKey: machineIn.account.IdentityKey(),
+		SigningKey:  machineIn.account.SigningKey(),
+	}
+	var resetDevices []*id.Device
+	machineOut.OlmSessionReset = func(_ context.Context, device *id.Device) {
+		resetDevices = append(resetDevices, device)
+	}
+
+	for range 2 {
+		require.NoError(t, machineOut.ResetOlmSession(context.TODO(), device))
+	}
+	sessions, err := machineOut.CryptoStore.GetSessions(context.TODO(), device.IdentityKey)
+	require.NoError(t, err)
+	assert.Len(t, sessions, 2)
+	assert.Equal(t, 2, sentToDevice)
+	assert.Equal(t, []*id.Device{device, device}, resetDevices)
 }
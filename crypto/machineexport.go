@@ -0,0 +1,291 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix/crypto/olm"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrUnsupportedMachineExportVersion = errors.New("unsupported olm machine export version")
+	ErrMachineExportWrongDevice        = errors.New("olm machine export is for a different device")
+)
+
+const machineExportPrefix = "-----BEGIN MAUTRIX OLM MACHINE DATA-----\n"
+const machineExportSuffix = "-----END MAUTRIX OLM MACHINE DATA-----\n"
+
+const machineExportVersion1 = 1
+
+// ExportedOlmSession is a pickled Olm session inside an [OlmMachineExport].
+type ExportedOlmSession struct {
+	SenderKey         id.SenderKey `json:"sender_key"`
+	Pickle            string       `json:"pickle"`
+	CreationTime      time.Time    `json:"created_at"`
+	LastEncryptedTime time.Time    `json:"last_encrypted"`
+	LastDecryptedTime time.Time    `json:"last_decrypted"`
+}
+
+// ExportedMegolmSession is a pickled inbound Megolm session with all of its metadata inside an [OlmMachineExport].
+type ExportedMegolmSession struct {
+	RoomID           id.RoomID           `json:"room_id"`
+	SessionID        id.SessionID        `json:"session_id"`
+	SenderKey        id.SenderKey        `json:"sender_key"`
+	SigningKey       id.Ed25519          `json:"signing_key"`
+	Pickle           string              `json:"pickle"`
+	ForwardingChains []string            `json:"forwarding_chains"`
+	RatchetSafety    RatchetSafety       `json:"ratchet_safety"`
+	ReceivedAt       time.Time           `json:"received_at"`
+	MaxAge           int64               `json:"max_age"`
+	MaxMessages      int                 `json:"max_messages"`
+	IsScheduled      bool                `json:"is_scheduled"`
+	KeyBackupVersion id.KeyBackupVersion `json:"key_backup_version,omitempty"`
+	UnverifiedSource bool                `json:"unverified_source,omitempty"`
+}
+
+// OlmMachineExport is the decrypted content of a file created with [OlmMachine.ExportEncrypted].
+//
+// The pickles inside are encrypted with the export passphrase, but the whole export is also
+// encrypted with the same primitives (PBKDF2, AES-CTR and HMAC-SHA256) as Matrix key exports.
+type OlmMachineExport struct {
+	Version          int                      `json:"version"`
+	UserID           id.UserID                `json:"user_id"`
+	DeviceID         id.DeviceID              `json:"device_id"`
+	Account          string                   `json:"account"`
+	Shared           bool                     `json:"shared"`
+	KeyBackupVersion id.KeyBackupVersion      `json:"key_backup_version,omitempty"`
+	OlmSessions      []*ExportedOlmSession    `json:"olm_sessions"`
+	MegolmSessions   []*ExportedMegolmSession `json:"megolm_sessions"`
+}
+
+type olmSessionLister interface {
+	GetAllSessions(ctx context.Context) (map[id.SenderKey]OlmSessionList, error)
+}
+
+// ExportEncrypted exports the Olm account, Olm sessions and inbound Megolm sessions of this machine
+// into a passphrase-protected blob, which can be imported into another store using [OlmMachine.ImportEncrypted].
+//
+// Olm sessions are only included if the crypto store supports listing them (both built-in stores do).
+// Outbound Megolm sessions are not included, because the store interface can't list them or tell which devices
+// they were shared with. The inbound copy of every outbound session is exported, so messages sent with them
+// can still be decrypted, and the machine will just create a new outbound session the next time it sends a message.
+func (mach *OlmMachine) ExportEncrypted(ctx context.Context, passphrase string) ([]byte, error) {
+	pickleKey := []byte(passphrase)
+	accountPickle, err := mach.account.Internal.Pickle(pickleKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pickle account: %w", err)
+	}
+	export := &OlmMachineExport{
+		Version:          machineExportVersion1,
+		UserID:           mach.Client.UserID,
+		DeviceID:         mach.Client.DeviceID,
+		Account:          string(accountPickle),
+		Shared:           mach.account.Shared,
+		KeyBackupVersion: mach.account.KeyBackupVersion,
+		OlmSessions:      []*ExportedOlmSession{},
+	}
+	if lister, ok := mach.CryptoStore.(olmSessionLister); ok {
+		allSessions, err := lister.GetAllSessions(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get olm sessions: %w", err)
+		}
+		for senderKey, sessions := range allSessions {
+			for _, session := range sessions {
+				pickle, err := session.Internal.Pickle(pickleKey)
+				if err != nil {
+					return nil, fmt.Errorf("failed to pickle olm session %s: %w", session.ID(), err)
+				}
+				export.OlmSessions = append(export.OlmSessions, &ExportedOlmSession{
+					SenderKey:         senderKey,
+					Pickle:            string(pickle),
+					CreationTime:      session.CreationTime,
+					LastEncryptedTime: session.LastEncryptedTime,
+					LastDecryptedTime: session.LastDecryptedTime,
+				})
+			}
+		}
+	}
+	groupSessions, err := mach.CryptoStore.GetAllGroupSessions(ctx).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get megolm sessions: %w", err)
+	}
+	export.MegolmSessions = make([]*ExportedMegolmSession, len(groupSessions))
+	for i, session := range groupSessions {
+		pickle, err := session.Internal.Pickle(pickleKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pickle megolm session %s: %w", session.ID(), err)
+		}
+		export.MegolmSessions[i] = &ExportedMegolmSession{
+			RoomID:           session.RoomID,
+			SessionID:        session.ID(),
+			SenderKey:        session.SenderKey,
+			SigningKey:       session.SigningKey,
+			Pickle:           string(pickle),
+			ForwardingChains: session.ForwardingChains,
+			RatchetSafety:    session.RatchetSafety,
+			ReceivedAt:       session.ReceivedAt,
+			MaxAge:           session.MaxAge,
+			MaxMessages:      session.MaxMessages,
+			IsScheduled:      session.IsScheduled,
+			KeyBackupVersion: session.KeyBackupVersion,
+			UnverifiedSource: session.UnverifiedSource,
+		}
+	}
+	data, err := json.Marshal(export)
+	if err != nil {
+		return nil, err
+	}
+	return formatKeyExportData(machineExportPrefix, machineExportSuffix, encryptExportData(passphrase, data)), nil
+}
+
+// ImportEncrypted imports data created with [OlmMachine.ExportEncrypted], replacing the current Olm account.
+//
+// The export must be for the same user and device as the machine. Megolm sessions are only imported
+// if the store doesn't already have an equivalent or better version of them. Everything in the export
+// is unpickled before anything is written to the store. If the crypto store supports transactions
+// (the SQL store does), all writes are also done in a single transaction, so a failed import doesn't
+// leave the machine with a mix of old and new data.
+func (mach *OlmMachine) ImportEncrypted(ctx context.Context, passphrase string, data []byte) error {
+	exportData, err := decodeKeyExport(data, machineExportPrefix, machineExportSuffix)
+	if err != nil {
+		return err
+	}
+	decrypted, err := decryptExportData(passphrase, exportData)
+	if err != nil {
+		return err
+	}
+	var export OlmMachineExport
+	err = json.Unmarshal(decrypted, &export)
+	if err != nil {
+		return fmt.Errorf("invalid export json: %w", err)
+	} else if export.Version != machineExportVersion1 {
+		return ErrUnsupportedMachineExportVersion
+	} else if export.UserID != mach.Client.UserID || export.DeviceID != mach.Client.DeviceID {
+		return fmt.Errorf("%w (%s/%s)", ErrMachineExportWrongDevice, export.UserID, export.DeviceID)
+	}
+
+	pickleKey := []byte(passphrase)
+	accountInternal, err := olm.AccountFromPickled([]byte(export.Account), pickleKey)
+	if err != nil {
+		return fmt.Errorf("failed to unpickle account: %w", err)
+	}
+	account := &OlmAccount{
+		Internal:         accountInternal,
+		Shared:           export.Shared,
+		KeyBackupVersion: export.KeyBackupVersion,
+	}
+	olmSessions := make([]*OlmSession, len(export.OlmSessions))
+	for i, exported := range export.OlmSessions {
+		internal, err := olm.SessionFromPickled([]byte(exported.Pickle), pickleKey)
+		if err != nil {
+			return fmt.Errorf("failed to unpickle olm session: %w", err)
+		}
+		session := &OlmSession{Internal: internal}
+		session.CreationTime = exported.CreationTime
+		session.LastEncryptedTime = exported.LastEncryptedTime
+		session.LastDecryptedTime = exported.LastDecryptedTime
+		olmSessions[i] = session
+	}
+	megolmSessions := make([]*InboundGroupSession, len(export.MegolmSessions))
+	for i, exported := range export.MegolmSessions {
+		internal, err := olm.InboundGroupSessionFromPickled([]byte(exported.Pickle), pickleKey)
+		if err != nil {
+			return fmt.Errorf("failed to unpickle megolm session %s: %w", exported.SessionID, err)
+		} else if internal.ID() != exported.SessionID {
+			return fmt.Errorf("%w (%s != %s)", ErrMismatchingExportedSessionID, internal.ID(), exported.SessionID)
+		}
+		megolmSessions[i] = &InboundGroupSession{
+			Internal:         internal,
+			SigningKey:       exported.SigningKey,
+			SenderKey:        exported.SenderKey,
+			RoomID:           exported.RoomID,
+			ForwardingChains: exported.ForwardingChains,
+			RatchetSafety:    exported.RatchetSafety,
+			ReceivedAt:       exported.ReceivedAt,
+			MaxAge:           exported.MaxAge,
+			MaxMessages:      exported.MaxMessages,
+			IsScheduled:      exported.IsScheduled,
+			KeyBackupVersion: exported.KeyBackupVersion,
+			UnverifiedSource: exported.UnverifiedSource,
+			id:               exported.SessionID,
+		}
+	}
+
+	var received []*InboundGroupSession
+	err = mach.doStoreTxn(ctx, func(ctx context.Context) error {
+		received = received[:0]
+		err := mach.CryptoStore.PutAccount(ctx, account)
+		if err != nil {
+			return fmt.Errorf("failed to save account: %w", err)
+		}
+		for i, session := range olmSessions {
+			senderKey := export.OlmSessions[i].SenderKey
+			existing, err := mach.CryptoStore.GetSessions(ctx, senderKey)
+			if err != nil {
+				return fmt.Errorf("failed to get existing olm sessions: %w", err)
+			} else if existing.hasSession(session.ID()) {
+				continue
+			}
+			err = mach.CryptoStore.AddSession(ctx, senderKey, session)
+			if err != nil {
+				return fmt.Errorf("failed to store olm session: %w", err)
+			}
+		}
+		for _, session := range megolmSessions {
+			existing, err := mach.CryptoStore.GetGroupSession(ctx, session.RoomID, session.ID())
+			if err != nil && !errors.Is(err, ErrGroupSessionWithheld) {
+				return fmt.Errorf("failed to get existing megolm session %s: %w", session.ID(), err)
+			} else if existing != nil && existing.Internal.FirstKnownIndex() <= session.Internal.FirstKnownIndex() {
+				continue
+			}
+			err = mach.CryptoStore.PutGroupSession(ctx, session)
+			if err != nil {
+				return fmt.Errorf("failed to store megolm session %s: %w", session.ID(), err)
+			}
+			received = append(received, session)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	mach.account = account
+
+	ctx, flushReceivedSessions := mach.WithSessionReceivedBatch(ctx)
+	defer flushReceivedSessions()
+	for _, session := range received {
+		mach.MarkSessionReceived(ctx, session.RoomID, session.ID(), session.Internal.FirstKnownIndex())
+	}
+	return nil
+}
+
+// storeTransactor is an optional interface for crypto stores that can run multiple writes in one transaction.
+type storeTransactor interface {
+	DoTxn(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+func (mach *OlmMachine) doStoreTxn(ctx context.Context, fn func(ctx context.Context) error) error {
+	if txnStore, ok := mach.CryptoStore.(storeTransactor); ok {
+		return txnStore.DoTxn(ctx, fn)
+	}
+	return fn(ctx)
+}
+
+func (o OlmSessionList) hasSession(sessionID id.SessionID) bool {
+	for _, session := range o {
+		if session.ID() == sessionID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func TestOlmMachine_ExportEncrypted(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "user1")
+	otherMach := newMachine(t, "user2")
+
+	var otkKey id.Curve25519
+	for _, otk := range otherMach.account.getOneTimeKeys("user2", "device1", 0) {
+		otkKey = otk.Key
+		break
+	}
+	olmSession, err := mach.account.Internal.NewOutboundSession(otherMach.account.IdentityKey(), otkKey)
+	require.NoError(t, err)
+	wrapped := wrapSession(olmSession)
+	require.NoError(t, mach.CryptoStore.AddSession(ctx, otherMach.account.IdentityKey(), wrapped))
+	megolmSession := newBackupTestSession(t, mach, "room1")
+	megolmSession.KeyBackupVersion = "5"
+	megolmSession.RatchetSafety = RatchetSafety{NextIndex: 3, MissedIndices: []uint{1}}
+	require.NoError(t, mach.CryptoStore.PutGroupSession(ctx, megolmSession))
+
+	data, err := mach.ExportEncrypted(ctx, "hunter2")
+	require.NoError(t, err)
+
+	newMach := newMachine(t, "user1")
+	assert.ErrorIs(t, newMach.ImportEncrypted(ctx, "wrong", data), ErrMismatchingExportHash)
+	assert.ErrorIs(t, otherMach.ImportEncrypted(ctx, "hunter2", data), ErrMachineExportWrongDevice)
+	require.NoError(t, newMach.ImportEncrypted(ctx, "hunter2", data))
+
+	assert.Equal(t, mach.account.IdentityKey(), newMach.account.IdentityKey())
+	assert.Equal(t, mach.account.SigningKey(), newMach.account.SigningKey())
+
+	sessions, err := newMach.CryptoStore.GetSessions(ctx, otherMach.account.IdentityKey())
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, wrapped.ID(), sessions[0].ID())
+
+	igs, err := newMach.CryptoStore.GetGroupSession(ctx, "room1", megolmSession.ID())
+	require.NoError(t, err)
+	require.NotNil(t, igs)
+	assert.Equal(t, megolmSession.SenderKey, igs.SenderKey)
+	assert.Equal(t, megolmSession.SigningKey, igs.SigningKey)
+	assert.Equal(t, megolmSession.MaxAge, igs.MaxAge)
+	assert.Equal(t, megolmSession.MaxMessages, igs.MaxMessages)
+	assert.Equal(t, megolmSession.KeyBackupVersion, igs.KeyBackupVersion)
+	assert.Equal(t, megolmSession.RatchetSafety, igs.RatchetSafety)
+	assert.True(t, megolmSession.ReceivedAt.Equal(igs.ReceivedAt))
+
+	// Importing again must not duplicate olm sessions
+	require.NoError(t, newMach.ImportEncrypted(ctx, "hunter2", data))
+	sessions, err = newMach.CryptoStore.GetSessions(ctx, otherMach.account.IdentityKey())
+	require.NoError(t, err)
+	assert.Len(t, sessions, 1)
+}
+
+func TestOlmMachine_ImportEncrypted_BadPickle(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "user1")
+	newBackupTestSession(t, mach, "room1")
+	data, err := mach.ExportEncrypted(ctx, "hunter2")
+	require.NoError(t, err)
+	exportData, err := decodeKeyExport(data, machineExportPrefix, machineExportSuffix)
+	require.NoError(t, err)
+	decrypted, err := decryptExportData("hunter2", exportData)
+	require.NoError(t, err)
+	var export OlmMachineExport
+	require.NoError(t, json.Unmarshal(decrypted, &export))
+	require.Len(t, export.MegolmSessions, 1)
+	export.MegolmSessions[0].Pickle = "invalid"
+	decrypted, err = json.Marshal(&export)
+	require.NoError(t, err)
+	data = formatKeyExportData(machineExportPrefix, machineExportSuffix, encryptExportData("hunter2", decrypted))
+
+	newMach := newMachine(t, "user1")
+	oldIdentityKey := newMach.account.IdentityKey()
+	assert.Error(t, newMach.ImportEncrypted(ctx, "hunter2", data))
+	assert.Equal(t, oldIdentityKey, newMach.account.IdentityKey())
+	account, err := newMach.CryptoStore.GetAccount(ctx)
+	require.NoError(t, err)
+	if account != nil {
+		assert.Equal(t, oldIdentityKey, account.IdentityKey())
+	}
+}
+
+type failingPutGroupSessionStore struct {
+	*SQLCryptoStore
+}
+
+func (failingPutGroupSessionStore) PutGroupSession(context.Context, *InboundGroupSession) error {
+	return errors.New("database is on fire")
+}
+
+func TestOlmMachine_ImportEncrypted_Rollback(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "user1")
+	otherMach := newMachine(t, "user2")
+	var otkKey id.Curve25519
+	for _, otk := range otherMach.account.getOneTimeKeys("user2", "device1", 0) {
+		otkKey = otk.Key
+		break
+	}
+	olmSession, err := mach.account.Internal.NewOutboundSession(otherMach.account.IdentityKey(), otkKey)
+	require.NoError(t, err)
+	require.NoError(t, mach.CryptoStore.AddSession(ctx, otherMach.account.IdentityKey(), wrapSession(olmSession)))
+	newBackupTestSession(t, mach, "room1")
+	data, err := mach.ExportEncrypted(ctx, "hunter2")
+	require.NoError(t, err)
+
+	sqlStore := getCryptoStores(t)["sql"].(*SQLCryptoStore)
+	newMach := NewOlmMachine(mach.Client, nil, failingPutGroupSessionStore{sqlStore}, mockStateStore{})
+	require.NoError(t, newMach.Load(ctx))
+	require.NoError(t, newMach.saveAccount(ctx))
+	oldIdentityKey := newMach.account.IdentityKey()
+
+	assert.ErrorContains(t, newMach.ImportEncrypted(ctx, "hunter2", data), "database is on fire")
+	assert.Equal(t, oldIdentityKey, newMach.account.IdentityKey())
+	account, err := sqlStore.GetAccount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, oldIdentityKey, account.IdentityKey())
+	sqlStore.Account = nil
+	account, err = sqlStore.GetAccount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, oldIdentityKey, account.IdentityKey())
+	sessions, err := sqlStore.GetSessions(ctx, otherMach.account.IdentityKey())
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestOlmMachine_ExportRoomKeys(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "user1")
+	session := newBackupTestSession(t, mach, "room1")
+
+	r, err := mach.ExportRoomKeys(ctx, "hunter2")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte("-----BEGIN MEGOLM SESSION DATA-----\n")))
+
+	newMach := newMachine(t, "user2")
+	imported, total, err := newMach.ImportRoomKeys(ctx, bytes.NewReader(data), "hunter2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, imported)
+	assert.Equal(t, 1, total)
+	igs, err := newMach.CryptoStore.GetGroupSession(ctx, "room1", session.ID())
+	require.NoError(t, err)
+	require.NotNil(t, igs)
+	assert.Equal(t, session.SigningKey, igs.SigningKey)
+}
+
+// Test vectors from Element's MegolmExportEncryption tests. They don't have a newline after the suffix.
+var elementKeyExportVectors = []struct {
+	plaintext  string
+	passphrase string
+	export     string
+}{{
+	plaintext:  "plain",
+	passphrase: "password",
+	export: "-----BEGIN MEGOLM SESSION DATA-----\n" +
+		"AXNhbHRzYWx0c2FsdHNhbHSIiIiIiIiIiIiIiIiIiIiIAAAACmIRUW2OjZ3L2l6j9h0lHlV3M2dx\n" +
+		"cissyYBxjsfsAndErh065A8=\n" +
+		"-----END MEGOLM SESSION DATA-----",
+}, {
+	plaintext:  "Hello, World",
+	passphrase: "betterpassword",
+	export: "-----BEGIN MEGOLM SESSION DATA-----\n" +
+		"AW1vcmVzYWx0bW9yZXNhbHT//////////wAAAAAAAAAAAAAD6KyBpe1Niv5M5NPm4ZATsJo5nghk\n" +
+		"KYu63a0YQ5DRhUWEKk7CcMkrKnAUiZny\n" +
+		"-----END MEGOLM SESSION DATA-----",
+}}
+
+func TestDecryptElementKeyExport(t *testing.T) {
+	for _, vector := range elementKeyExportVectors {
+		exportData, err := decodeKeyExport([]byte(vector.export), exportPrefix, exportSuffix)
+		require.NoError(t, err)
+		decrypted, err := decryptExportData(vector.passphrase, exportData)
+		require.NoError(t, err)
+		assert.Equal(t, vector.plaintext, string(decrypted))
+		_, err = decryptExportData(vector.passphrase+"x", exportData)
+		assert.ErrorIs(t, err, ErrMismatchingExportHash)
+	}
+}
+
+func TestOlmMachine_ImportRoomKeys_ElementLayout(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "user1")
+	session1 := newBackupTestSession(t, mach, "!room1:example.com")
+	session2 := newBackupTestSession(t, mach, "!room2:example.com")
+
+	// Element writes a JSON array of sessions in this layout, with some extra fields that must be ignored.
+	var sessions []map[string]any
+	for _, session := range []*InboundGroupSession{session1, session2} {
+		exported, err := session.export()
+		require.NoError(t, err)
+		sessions = append(sessions, map[string]any{
+			"algorithm":                       id.AlgorithmMegolmV1,
+			"forwarding_curve25519_key_chain": []string{},
+			"room_id":                         session.RoomID,
+			"sender_claimed_keys":             map[string]any{"ed25519": session.SigningKey},
+			"sender_key":                      session.SenderKey,
+			"session_id":                      session.ID(),
+			"session_key":                     exported.SessionKey,
+			"shared_history":                  false,
+			"untrusted":                       false,
+		})
+	}
+	plaintext, err := json.Marshal(sessions)
+	require.NoError(t, err)
+	data, err := EncryptKeyExport("correct horse battery staple", plaintext)
+	require.NoError(t, err)
+	data = bytes.TrimSuffix(data, []byte("\n"))
+
+	newMach := newMachine(t, "user2")
+	imported, total, err := newMach.ImportRoomKeys(ctx, bytes.NewReader(data), "correct horse battery staple")
+	require.NoError(t, err)
+	assert.Equal(t, 2, imported)
+	assert.Equal(t, 2, total)
+	for _, session := range []*InboundGroupSession{session1, session2} {
+		igs, err := newMach.CryptoStore.GetGroupSession(ctx, session.RoomID, session.ID())
+		require.NoError(t, err)
+		require.NotNil(t, igs)
+		assert.Equal(t, session.ID(), igs.ID())
+		assert.Equal(t, session.SenderKey, igs.SenderKey)
+		assert.Equal(t, session.SigningKey, igs.SigningKey)
+	}
+}
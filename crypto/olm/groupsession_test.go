@@ -1,6 +1,7 @@
 package olm_test
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 
@@ -9,6 +10,7 @@ import (
 
 	"maunium.net/go/mautrix/crypto/goolm/session"
 	"maunium.net/go/mautrix/crypto/libolm"
+	"maunium.net/go/mautrix/crypto/olm"
 )
 
 // TestEncryptDecrypt_GoolmToLibolm tests encryption where goolm encrypts and libolm decrypts
@@ -46,3 +48,55 @@ func TestEncryptDecrypt_LibolmToGoolm(t *testing.T) {
 		assert.Equal(t, libolmOutbound.MessageIndex()-1, msgIdx)
 	}
 }
+
+func TestInboundGroupSessionPickle_CrossBackend(t *testing.T) {
+	pickleKey := []byte("test")
+	goolmOutbound, err := session.NewMegolmOutboundSession()
+	require.NoError(t, err)
+	sessionKey := []byte(goolmOutbound.Key())
+	testCases := []struct {
+		name     string
+		create   func() (olm.InboundGroupSession, error)
+		unpickle func(pickled, key []byte) (olm.InboundGroupSession, error)
+	}{
+		{
+			name: "LibolmToGoolm",
+			create: func() (olm.InboundGroupSession, error) {
+				return libolm.NewInboundGroupSession(bytes.Clone(sessionKey))
+			},
+			unpickle: func(pickled, key []byte) (olm.InboundGroupSession, error) {
+				return session.MegolmInboundSessionFromPickled(pickled, key)
+			},
+		},
+		{
+			name: "GoolmToLibolm",
+			create: func() (olm.InboundGroupSession, error) {
+				return session.NewMegolmInboundSession(bytes.Clone(sessionKey))
+			},
+			unpickle: func(pickled, key []byte) (olm.InboundGroupSession, error) {
+				return libolm.InboundGroupSessionFromPickled(pickled, key)
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			inbound, err := tc.create()
+			require.NoError(t, err)
+			pickled, err := inbound.Pickle(pickleKey)
+			require.NoError(t, err)
+			converted, err := tc.unpickle(bytes.Clone(pickled), pickleKey)
+			require.NoError(t, err)
+			assert.Equal(t, inbound.ID(), converted.ID())
+			assert.Equal(t, inbound.FirstKnownIndex(), converted.FirstKnownIndex())
+			repickled, err := converted.Pickle(pickleKey)
+			require.NoError(t, err)
+			assert.Equal(t, pickled, repickled, "pickle changed after converting between backends")
+
+			ciphertext, err := goolmOutbound.Encrypt([]byte("after conversion"))
+			require.NoError(t, err)
+			plaintext, _, err := converted.Decrypt(ciphertext)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("after conversion"), plaintext)
+		})
+	}
+}
@@ -9,6 +9,10 @@ package olm
 var GetVersion func() (major, minor, patch uint8)
 var SetPickleKeyImpl func(key []byte)
 
+// Driver is the name of the active olm implementation: "libolm" for the cgo bindings or "goolm" for the pure Go one.
+// It is set by whichever implementation package is imported, which is normally decided by the goolm build tag.
+var Driver string
+
 // Version returns the version number of the olm library.
 func Version() (major, minor, patch uint8) {
 	return GetVersion()
@@ -117,3 +117,72 @@ func TestSession_EncryptDecrypt(t *testing.T) {
 		}
 	}
 }
+
+func TestSessionPickle_CrossBackend(t *testing.T) {
+	pickleKey := []byte("test")
+	testCases := []struct {
+		name       string
+		newAccount func() olm.Account
+		unpickle   func(pickled, key []byte) (olm.Session, error)
+	}{
+		{
+			name:       "LibolmToGoolm",
+			newAccount: func() olm.Account { return exerrors.Must(libolm.NewAccount()) },
+			unpickle: func(pickled, key []byte) (olm.Session, error) {
+				return session.OlmSessionFromPickled(pickled, key)
+			},
+		},
+		{
+			name:       "GoolmToLibolm",
+			newAccount: func() olm.Account { return exerrors.Must(account.NewAccount()) },
+			unpickle: func(pickled, key []byte) (olm.Session, error) {
+				return libolm.SessionFromPickled(pickled, key)
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sender, receiver := tc.newAccount(), tc.newAccount()
+			require.NoError(t, receiver.GenOneTimeKeys(1))
+			_, receiverCurve25519, err := receiver.IdentityKeys()
+			require.NoError(t, err)
+			otks, err := receiver.OneTimeKeys()
+			require.NoError(t, err)
+			senderSession, err := sender.NewOutboundSession(receiverCurve25519, otks[maps.Keys(otks)[0]])
+			require.NoError(t, err)
+
+			msgType, ciphertext, err := senderSession.Encrypt([]byte("prekey"))
+			require.NoError(t, err)
+			receiverSession, err := receiver.NewInboundSession(string(ciphertext))
+			require.NoError(t, err)
+			_, err = receiverSession.Decrypt(string(ciphertext), msgType)
+			require.NoError(t, err)
+
+			// Leave one message undelivered so the sender session has a skipped message key.
+			skippedType, skipped, err := receiverSession.Encrypt([]byte("skipped"))
+			require.NoError(t, err)
+			msgType, ciphertext, err = receiverSession.Encrypt([]byte("delivered"))
+			require.NoError(t, err)
+			_, err = senderSession.Decrypt(string(ciphertext), msgType)
+			require.NoError(t, err)
+
+			pickled, err := senderSession.Pickle(pickleKey)
+			require.NoError(t, err)
+			converted, err := tc.unpickle(bytes.Clone(pickled), pickleKey)
+			require.NoError(t, err)
+			assert.Equal(t, senderSession.ID(), converted.ID())
+			repickled, err := converted.Pickle(pickleKey)
+			require.NoError(t, err)
+			assert.Equal(t, pickled, repickled, "pickle changed after converting between backends")
+
+			decrypted, err := converted.Decrypt(string(skipped), skippedType)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("skipped"), decrypted)
+			msgType, ciphertext, err = converted.Encrypt([]byte("after conversion"))
+			require.NoError(t, err)
+			decrypted, err = receiverSession.Decrypt(string(ciphertext), msgType)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("after conversion"), decrypted)
+		})
+	}
+}
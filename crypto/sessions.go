@@ -111,6 +111,9 @@ type InboundGroupSession struct {
 	MaxMessages      int
 	IsScheduled      bool
 	KeyBackupVersion id.KeyBackupVersion
+	// UnverifiedSource is set for sessions imported from key backup whose claimed sender keys
+	// couldn't be confirmed against a known device when the session was imported.
+	UnverifiedSource bool
 
 	id id.SessionID
 }
@@ -163,7 +166,7 @@ func (igs *InboundGroupSession) export() (*ExportedSession, error) {
 		ForwardingChains:  igs.ForwardingChains,
 		RoomID:            igs.RoomID,
 		SenderKey:         igs.SenderKey,
-		SenderClaimedKeys: SenderClaimedKeys{},
+		SenderClaimedKeys: SenderClaimedKeys{Ed25519: igs.SigningKey},
 		SessionID:         igs.ID(),
 		SessionKey:        string(key),
 	}, nil
@@ -253,6 +256,11 @@ func (ogs *OutboundGroupSession) Expired() bool {
 	return ogs.MessageCount >= ogs.MaxMessages || ogs.ExpirationMixin.Expired()
 }
 
+// RemainingMessages returns the number of messages that can still be encrypted before the session is rotated.
+func (ogs *OutboundGroupSession) RemainingMessages() int {
+	return max(ogs.MaxMessages-ogs.MessageCount, 0)
+}
+
 func (ogs *OutboundGroupSession) Encrypt(plaintext []byte) ([]byte, error) {
 	if !ogs.Shared {
 		return nil, SessionNotShared
@@ -281,3 +289,11 @@ func (exp *ExpirationMixin) Expired() bool {
 	}
 	return exp.CreationTime.Add(exp.MaxAge).Before(time.Now())
 }
+
+// ExpiresAt returns the time when the session expires, or a zero time if the session doesn't have a maximum age.
+func (exp *ExpirationMixin) ExpiresAt() time.Time {
+	if exp.MaxAge == 0 {
+		return time.Time{}
+	}
+	return exp.CreationTime.Add(exp.MaxAge)
+}
@@ -72,6 +72,20 @@ func (store *SQLCryptoStore) Flush(_ context.Context) error {
 	return nil
 }
 
+// DoTxn runs the given function in a database transaction. If the function returns an error, the transaction is
+// rolled back, the cached account is restored and cached Olm sessions are dropped so that they're reloaded from the database.
+func (store *SQLCryptoStore) DoTxn(ctx context.Context, fn func(ctx context.Context) error) error {
+	prevAccount := store.Account
+	err := store.DB.DoTxn(ctx, nil, fn)
+	if err != nil {
+		store.Account = prevAccount
+		store.olmSessionCacheLock.Lock()
+		store.olmSessionCache = make(map[id.SenderKey]map[id.SessionID]*OlmSession)
+		store.olmSessionCacheLock.Unlock()
+	}
+	return err
+}
+
 // PutNextBatch stores the next sync batch token for the current account.
 func (store *SQLCryptoStore) PutNextBatch(ctx context.Context, nextBatch string) error {
 	store.SyncToken = nextBatch
@@ -162,6 +176,32 @@ func (store *SQLCryptoStore) GetAccount(ctx context.Context) (*OlmAccount, error
 	return store.Account, nil
 }
 
+// GetAllSessions returns all Olm sessions in the store grouped by sender key.
+func (store *SQLCryptoStore) GetAllSessions(ctx context.Context) (map[id.SenderKey]OlmSessionList, error) {
+	rows, err := store.DB.Query(ctx, "SELECT sender_key, session, created_at, last_encrypted, last_decrypted FROM crypto_olm_session WHERE account_id=$1 ORDER BY last_decrypted DESC",
+		store.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := make(map[id.SenderKey]OlmSessionList)
+	for rows.Next() {
+		sess := OlmSession{Internal: olm.NewBlankSession()}
+		var sessionBytes []byte
+		var senderKey id.SenderKey
+		err = rows.Scan(&senderKey, &sessionBytes, &sess.CreationTime, &sess.LastEncryptedTime, &sess.LastDecryptedTime)
+		if err != nil {
+			return nil, err
+		}
+		err = sess.Internal.Unpickle(sessionBytes, store.PickleKey)
+		if err != nil {
+			return nil, err
+		}
+		sessions[senderKey] = append(sessions[senderKey], &sess)
+	}
+	return sessions, rows.Err()
+}
+
 // HasSession returns whether there is an Olm session for the given sender key.
 func (store *SQLCryptoStore) HasSession(ctx context.Context, key id.SenderKey) bool {
 	store.olmSessionCacheLock.Lock()
@@ -345,22 +385,23 @@ func (store *SQLCryptoStore) PutGroupSession(ctx context.Context, session *Inbou
 		Int("max_messages", session.MaxMessages).
 		Bool("is_scheduled", session.IsScheduled).
 		Stringer("key_backup_version", session.KeyBackupVersion).
+		Bool("unverified_source", session.UnverifiedSource).
 		Msg("Upserting megolm inbound group session")
 	_, err = store.DB.Exec(ctx, `
 		INSERT INTO crypto_megolm_inbound_session (
 			session_id, sender_key, signing_key, room_id, session, forwarding_chains,
-			ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, account_id
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, unverified_source, account_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 		ON CONFLICT (session_id, account_id) DO UPDATE
 		    SET withheld_code=NULL, withheld_reason=NULL, sender_key=excluded.sender_key, signing_key=excluded.signing_key,
 		        room_id=excluded.room_id, session=excluded.session, forwarding_chains=excluded.forwarding_chains,
 		        ratchet_safety=excluded.ratchet_safety, received_at=excluded.received_at,
 		        max_age=excluded.max_age, max_messages=excluded.max_messages, is_scheduled=excluded.is_scheduled,
-		        key_backup_version=excluded.key_backup_version
+		        key_backup_version=excluded.key_backup_version, unverified_source=excluded.unverified_source
 	`,
 		session.ID(), session.SenderKey, session.SigningKey, session.RoomID, sessionBytes, forwardingChains,
 		ratchetSafety, datePtr(session.ReceivedAt), dbutil.NumPtr(session.MaxAge), dbutil.NumPtr(session.MaxMessages),
-		session.IsScheduled, session.KeyBackupVersion, store.AccountID,
+		session.IsScheduled, session.KeyBackupVersion, session.UnverifiedSource, store.AccountID,
 	)
 	return err
 }
@@ -371,14 +412,14 @@ func (store *SQLCryptoStore) GetGroupSession(ctx context.Context, roomID id.Room
 	var sessionBytes, ratchetSafetyBytes []byte
 	var receivedAt sql.NullTime
 	var maxAge, maxMessages sql.NullInt64
-	var isScheduled bool
+	var isScheduled, unverifiedSource bool
 	var version id.KeyBackupVersion
 	err := store.DB.QueryRow(ctx, `
-		SELECT sender_key, signing_key, session, forwarding_chains, withheld_code, withheld_reason, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version
+		SELECT sender_key, signing_key, session, forwarding_chains, withheld_code, withheld_reason, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, unverified_source
 		FROM crypto_megolm_inbound_session
 		WHERE room_id=$1 AND session_id=$2 AND account_id=$3`,
 		roomID, sessionID, store.AccountID,
-	).Scan(&senderKey, &signingKey, &sessionBytes, &forwardingChains, &withheldCode, &withheldReason, &ratchetSafetyBytes, &receivedAt, &maxAge, &maxMessages, &isScheduled, &version)
+	).Scan(&senderKey, &signingKey, &sessionBytes, &forwardingChains, &withheldCode, &withheldReason, &ratchetSafetyBytes, &receivedAt, &maxAge, &maxMessages, &isScheduled, &version, &unverifiedSource)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	} else if err != nil {
@@ -409,6 +450,7 @@ func (store *SQLCryptoStore) GetGroupSession(ctx context.Context, roomID id.Room
 		MaxMessages:      int(maxMessages.Int64),
 		IsScheduled:      isScheduled,
 		KeyBackupVersion: version,
+		UnverifiedSource: unverifiedSource,
 	}, nil
 }
 
@@ -531,9 +573,9 @@ func (store *SQLCryptoStore) scanInboundGroupSession(rows dbutil.Scannable) (*In
 	var sessionBytes, ratchetSafetyBytes []byte
 	var receivedAt sql.NullTime
 	var maxAge, maxMessages sql.NullInt64
-	var isScheduled bool
+	var isScheduled, unverifiedSource bool
 	var version id.KeyBackupVersion
-	err := rows.Scan(&roomID, &senderKey, &signingKey, &sessionBytes, &forwardingChains, &ratchetSafetyBytes, &receivedAt, &maxAge, &maxMessages, &isScheduled, &version)
+	err := rows.Scan(&roomID, &senderKey, &signingKey, &sessionBytes, &forwardingChains, &ratchetSafetyBytes, &receivedAt, &maxAge, &maxMessages, &isScheduled, &version, &unverifiedSource)
 	if err != nil {
 		return nil, err
 	}
@@ -553,12 +595,13 @@ func (store *SQLCryptoStore) scanInboundGroupSession(rows dbutil.Scannable) (*In
 		MaxMessages:      int(maxMessages.Int64),
 		IsScheduled:      isScheduled,
 		KeyBackupVersion: version,
+		UnverifiedSource: unverifiedSource,
 	}, nil
 }
 
 func (store *SQLCryptoStore) GetGroupSessionsForRoom(ctx context.Context, roomID id.RoomID) dbutil.RowIter[*InboundGroupSession] {
 	rows, err := store.DB.Query(ctx, `
-		SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version
+		SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, unverified_source
 		FROM crypto_megolm_inbound_session WHERE room_id=$1 AND account_id=$2 AND session IS NOT NULL`,
 		roomID, store.AccountID,
 	)
@@ -567,7 +610,7 @@ func (store *SQLCryptoStore) GetGroupSessionsForRoom(ctx context.Context, roomID
 
 func (store *SQLCryptoStore) GetAllGroupSessions(ctx context.Context) dbutil.RowIter[*InboundGroupSession] {
 	rows, err := store.DB.Query(ctx, `
-		SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version
+		SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, unverified_source
 		FROM crypto_megolm_inbound_session WHERE account_id=$1 AND session IS NOT NULL`,
 		store.AccountID,
 	)
@@ -576,13 +619,128 @@ func (store *SQLCryptoStore) GetAllGroupSessions(ctx context.Context) dbutil.Row
 
 func (store *SQLCryptoStore) GetGroupSessionsWithoutKeyBackupVersion(ctx context.Context, version id.KeyBackupVersion) dbutil.RowIter[*InboundGroupSession] {
 	rows, err := store.DB.Query(ctx, `
-		SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version
+		SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, unverified_source
 		FROM crypto_megolm_inbound_session WHERE account_id=$1 AND session IS NOT NULL AND key_backup_version != $2`,
 		store.AccountID, version,
 	)
 	return dbutil.NewRowIterWithError(rows, store.scanInboundGroupSession, err)
 }
 
+// MarkGroupSessionsBackedUp sets the key backup version of the given inbound Megolm sessions.
+// Only the key_backup_version column is updated, so concurrent changes to the sessions themselves aren't overwritten.
+func (store *SQLCryptoStore) MarkGroupSessionsBackedUp(ctx context.Context, version id.KeyBackupVersion, sessionIDs []id.SessionID) error {
+	return store.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		for _, sessionID := range sessionIDs {
+			_, err := store.DB.Exec(ctx, `
+				UPDATE crypto_megolm_inbound_session SET key_backup_version=$1 WHERE account_id=$2 AND session_id=$3`,
+				version, store.AccountID, sessionID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GroupSessionMetadata contains the metadata of an inbound Megolm session without the session data itself.
+type GroupSessionMetadata struct {
+	RoomID           id.RoomID
+	SessionID        id.SessionID
+	SenderKey        id.SenderKey
+	SigningKey       id.Ed25519
+	ReceivedAt       time.Time
+	MaxAge           int64
+	MaxMessages      int
+	IsScheduled      bool
+	KeyBackupVersion id.KeyBackupVersion
+	UnverifiedSource bool
+}
+
+// GroupSessionMetadataQuery contains the filters for [SQLCryptoStore.ListGroupSessionMetadata].
+type GroupSessionMetadataQuery struct {
+	// If set, only sessions in this room are returned.
+	RoomID id.RoomID
+	// If set, only sessions that haven't been stored in this key backup version are returned.
+	NotInKeyBackupVersion id.KeyBackupVersion
+	// Pagination cursor: only sessions with an ID lexically after this one are returned.
+	// Use the session ID of the last item of the previous page to get the next page.
+	After id.SessionID
+	// Maximum number of sessions to return. Zero means no limit.
+	Limit int
+}
+
+// ListGroupSessionMetadata lists the metadata of inbound Megolm sessions in the store, ordered by session ID.
+// Withheld sessions are not included.
+func (store *SQLCryptoStore) ListGroupSessionMetadata(ctx context.Context, query GroupSessionMetadataQuery) ([]*GroupSessionMetadata, error) {
+	conditions := []string{"account_id=$1", "session IS NOT NULL"}
+	args := []any{store.AccountID}
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if query.RoomID != "" {
+		addCondition("room_id=$%d", query.RoomID)
+	}
+	if query.NotInKeyBackupVersion != "" {
+		addCondition("key_backup_version<>$%d", query.NotInKeyBackupVersion)
+	}
+	if query.After != "" {
+		addCondition("session_id>$%d", query.After)
+	}
+	q := fmt.Sprintf(`
+		SELECT room_id, session_id, sender_key, signing_key, received_at, max_age, max_messages, is_scheduled, key_backup_version, unverified_source
+		FROM crypto_megolm_inbound_session WHERE %s ORDER BY session_id`, strings.Join(conditions, " AND "))
+	if query.Limit > 0 {
+		args = append(args, query.Limit)
+		q += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	rows, err := store.DB.Query(ctx, q, args...)
+	return dbutil.NewRowIterWithError(rows, scanGroupSessionMetadata, err).AsList()
+}
+
+func scanGroupSessionMetadata(rows dbutil.Scannable) (*GroupSessionMetadata, error) {
+	var meta GroupSessionMetadata
+	var signingKey sql.NullString
+	var receivedAt sql.NullTime
+	var maxAge, maxMessages sql.NullInt64
+	err := rows.Scan(
+		&meta.RoomID, &meta.SessionID, &meta.SenderKey, &signingKey, &receivedAt, &maxAge, &maxMessages,
+		&meta.IsScheduled, &meta.KeyBackupVersion, &meta.UnverifiedSource,
+	)
+	if err != nil {
+		return nil, err
+	}
+	meta.SigningKey = id.Ed25519(signingKey.String)
+	meta.ReceivedAt = receivedAt.Time
+	meta.MaxAge = maxAge.Int64
+	meta.MaxMessages = int(maxMessages.Int64)
+	return &meta, nil
+}
+
+// CountGroupSessionsPerRoom returns the number of inbound Megolm sessions in each room. Withheld sessions are not counted.
+func (store *SQLCryptoStore) CountGroupSessionsPerRoom(ctx context.Context) (map[id.RoomID]int, error) {
+	rows, err := store.DB.Query(ctx, `
+		SELECT room_id, COUNT(*) FROM crypto_megolm_inbound_session
+		WHERE account_id=$1 AND session IS NOT NULL GROUP BY room_id`,
+		store.AccountID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[id.RoomID]int)
+	for rows.Next() {
+		var roomID id.RoomID
+		var count int
+		if err = rows.Scan(&roomID, &count); err != nil {
+			return nil, err
+		}
+		counts[roomID] = count
+	}
+	return counts, rows.Err()
+}
+
 // AddOutboundGroupSession stores an outbound Megolm session, along with the information about the room and involved devices.
 func (store *SQLCryptoStore) AddOutboundGroupSession(ctx context.Context, session *OutboundGroupSession) error {
 	sessionBytes, err := session.Internal.Pickle(store.PickleKey)
@@ -738,6 +896,15 @@ func (store *SQLCryptoStore) FindDeviceByKey(ctx context.Context, userID id.User
 	))
 }
 
+// FindDeviceByIdentityKey finds a non-deleted device of any user by its identity key.
+func (store *SQLCryptoStore) FindDeviceByIdentityKey(ctx context.Context, identityKey id.IdentityKey) (*id.Device, error) {
+	return scanDevice(store.DB.QueryRow(ctx, `
+		SELECT user_id, device_id, identity_key, signing_key, trust, deleted, name
+		FROM crypto_device WHERE identity_key=$1 AND deleted=false LIMIT 1`,
+		identityKey,
+	))
+}
+
 const deviceInsertQuery = `
 INSERT INTO crypto_device (user_id, device_id, identity_key, signing_key, trust, deleted, name)
 VALUES ($1, $2, $3, $4, $5, $6, $7)
@@ -961,3 +1128,76 @@ func (store *SQLCryptoStore) DeleteSecret(ctx context.Context, name id.Secret) (
 	_, err = store.DB.Exec(ctx, "DELETE FROM crypto_secrets WHERE account_id=$1 AND name=$2", store.AccountID, name)
 	return
 }
+
+// pickledColumns lists all the tables and columns that contain data encrypted with the pickle key.
+// The key columns together with account_id must uniquely identify a row.
+var pickledColumns = []struct {
+	table  string
+	key    string
+	pickle string
+}{
+	{"crypto_account", "account_id", "account"},
+	{"crypto_olm_session", "session_id", "session"},
+	{"crypto_megolm_inbound_session", "session_id", "session"},
+	{"crypto_megolm_outbound_session", "room_id", "session"},
+	{"crypto_secrets", "name", "secret"},
+}
+
+// RotatePickleKey re-encrypts all pickled data of this account (the Olm account, Olm and Megolm sessions and secrets)
+// with a new pickle key. Everything is done in a single transaction, so if anything fails, the old key stays in use.
+//
+// The store must not be used by anything else while rotating, and any other processes using the same database
+// must be stopped before rotating and restarted with the new key afterwards. For large stores, this may take a while,
+// as every session has to be decrypted and re-encrypted.
+func (store *SQLCryptoStore) RotatePickleKey(ctx context.Context, oldKey, newKey []byte) error {
+	if len(newKey) == 0 {
+		return olm.ErrNoKeyProvided
+	}
+	err := store.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		for _, col := range pickledColumns {
+			if err := store.repickleColumn(ctx, col.table, col.key, col.pickle, oldKey, newKey); err != nil {
+				return fmt.Errorf("failed to rotate pickle key in %s: %w", col.table, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	store.PickleKey = newKey
+	return nil
+}
+
+func (store *SQLCryptoStore) repickleColumn(ctx context.Context, table, keyColumn, pickleColumn string, oldKey, newKey []byte) error {
+	type pickledRow struct {
+		key    string
+		pickle []byte
+	}
+	rows, err := store.DB.Query(ctx, fmt.Sprintf(
+		"SELECT %s, %s FROM %s WHERE account_id=$1 AND %s IS NOT NULL",
+		keyColumn, pickleColumn, table, pickleColumn,
+	), store.AccountID)
+	pickled, err := dbutil.NewRowIterWithError(rows, func(row dbutil.Scannable) (pr pickledRow, err error) {
+		err = row.Scan(&pr.key, &pr.pickle)
+		return
+	}, err).AsList()
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s=$1 WHERE account_id=$2 AND %s=$3", table, pickleColumn, keyColumn)
+	for _, row := range pickled {
+		plaintext, err := libolmpickle.Unpickle(oldKey, row.pickle)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", row.key, err)
+		}
+		repickled, err := libolmpickle.Pickle(newKey, plaintext)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", row.key, err)
+		}
+		_, err = store.DB.Exec(ctx, query, repickled, store.AccountID, row.key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,4 +1,4 @@
--- v0 -> v17 (compatible with v15+): Latest revision
+-- v0 -> v18 (compatible with v15+): Latest revision
 CREATE TABLE IF NOT EXISTS crypto_account (
 	account_id         TEXT    PRIMARY KEY,
 	device_id          TEXT    NOT NULL,
@@ -32,6 +32,7 @@ CREATE TABLE IF NOT EXISTS crypto_device (
 	name         TEXT     NOT NULL,
 	PRIMARY KEY (user_id, device_id)
 );
+CREATE INDEX crypto_device_identity_key_idx ON crypto_device (identity_key);
 
 CREATE TABLE IF NOT EXISTS crypto_olm_session (
 	account_id     TEXT,
@@ -71,6 +72,7 @@ CREATE TABLE IF NOT EXISTS crypto_megolm_inbound_session (
 	max_messages       INTEGER,
 	is_scheduled       BOOLEAN NOT NULL DEFAULT false,
 	key_backup_version TEXT NOT NULL DEFAULT '',
+	unverified_source  BOOLEAN NOT NULL DEFAULT false,
 	PRIMARY KEY (account_id, session_id)
 );
 
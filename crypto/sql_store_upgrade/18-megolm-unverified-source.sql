@@ -0,0 +1,3 @@
+-- v18 (compatible with v15+): Add unverified_source flag for megolm sessions imported from key backup
+ALTER TABLE crypto_megolm_inbound_session ADD COLUMN unverified_source BOOLEAN NOT NULL DEFAULT false;
+CREATE INDEX crypto_device_identity_key_idx ON crypto_device (identity_key);
@@ -91,6 +91,9 @@ type Store interface {
 	GetAllGroupSessions(context.Context) dbutil.RowIter[*InboundGroupSession]
 	// GetGroupSessionsWithoutKeyBackupVersion gets all the inbound Megolm sessions in the store that do not match given key backup version.
 	GetGroupSessionsWithoutKeyBackupVersion(context.Context, id.KeyBackupVersion) dbutil.RowIter[*InboundGroupSession]
+	// MarkGroupSessionsBackedUp sets the key backup version of the given inbound Megolm sessions
+	// without touching any other data of the sessions.
+	MarkGroupSessionsBackedUp(context.Context, id.KeyBackupVersion, []id.SessionID) error
 
 	// AddOutboundGroupSession inserts the given outbound Megolm session into the store.
 	//
@@ -242,6 +245,18 @@ func (gs *MemoryStore) GetSessions(_ context.Context, senderKey id.SenderKey) (O
 	return sessions, nil
 }
 
+func (gs *MemoryStore) GetAllSessions(_ context.Context) (map[id.SenderKey]OlmSessionList, error) {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+	sessions := make(map[id.SenderKey]OlmSessionList, len(gs.Sessions))
+	for senderKey, list := range gs.Sessions {
+		if len(list) > 0 {
+			sessions[senderKey] = slices.Clone(list)
+		}
+	}
+	return sessions, nil
+}
+
 func (gs *MemoryStore) AddSession(_ context.Context, senderKey id.SenderKey, session *OlmSession) error {
 	gs.lock.Lock()
 	defer gs.lock.Unlock()
@@ -447,6 +462,19 @@ func (gs *MemoryStore) GetGroupSessionsWithoutKeyBackupVersion(_ context.Context
 	return dbutil.NewSliceIter(result)
 }
 
+func (gs *MemoryStore) MarkGroupSessionsBackedUp(_ context.Context, version id.KeyBackupVersion, sessionIDs []id.SessionID) error {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+	for _, room := range gs.GroupSessions {
+		for _, sessionID := range sessionIDs {
+			if session, ok := room[sessionID]; ok {
+				session.KeyBackupVersion = version
+			}
+		}
+	}
+	return gs.save()
+}
+
 func (gs *MemoryStore) AddOutboundGroupSession(_ context.Context, session *OutboundGroupSession) error {
 	gs.lock.Lock()
 	defer gs.lock.Unlock()
@@ -577,6 +605,19 @@ func (gs *MemoryStore) FindDeviceByKey(_ context.Context, userID id.UserID, iden
 	return nil, nil
 }
 
+func (gs *MemoryStore) FindDeviceByIdentityKey(_ context.Context, identityKey id.IdentityKey) (*id.Device, error) {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+	for _, devices := range gs.Devices {
+		for _, device := range devices {
+			if device.IdentityKey == identityKey && !device.Deleted {
+				return device, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
 func (gs *MemoryStore) PutDevice(_ context.Context, userID id.UserID, device *id.Device) error {
 	gs.lock.Lock()
 	defer gs.lock.Unlock()
@@ -13,6 +13,7 @@ import (
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.mau.fi/util/dbutil"
 
@@ -184,6 +185,94 @@ func TestStoreMegolmSession(t *testing.T) {
 	}
 }
 
+func TestStoreMarkGroupSessionsBackedUp(t *testing.T) {
+	stores := getCryptoStores(t)
+	for storeName, store := range stores {
+		t.Run(storeName, func(t *testing.T) {
+			ctx := context.TODO()
+			acc := NewOlmAccount()
+			internal, err := olm.InboundGroupSessionFromPickled([]byte(groupSession), []byte("test"))
+			require.NoError(t, err)
+			igs := &InboundGroupSession{
+				Internal:   internal,
+				SigningKey: acc.SigningKey(),
+				SenderKey:  acc.IdentityKey(),
+				RoomID:     "room1",
+			}
+			require.NoError(t, store.PutGroupSession(ctx, igs))
+
+			// Simulate the session being changed by something else after it was read for uploading
+			updated := *igs
+			updated.ForwardingChains = []string{"updated"}
+			require.NoError(t, store.PutGroupSession(ctx, &updated))
+
+			require.NoError(t, store.MarkGroupSessionsBackedUp(ctx, "1", []id.SessionID{igs.ID()}))
+			retrieved, err := store.GetGroupSession(ctx, "room1", igs.ID())
+			require.NoError(t, err)
+			assert.Equal(t, id.KeyBackupVersion("1"), retrieved.KeyBackupVersion)
+			assert.Equal(t, []string{"updated"}, retrieved.ForwardingChains)
+		})
+	}
+}
+
+func TestStoreListGroupSessionMetadata(t *testing.T) {
+	store := getCryptoStores(t)["sql"].(*SQLCryptoStore)
+	acc := NewOlmAccount()
+	var sessionIDs []id.SessionID
+	for i, roomID := range []id.RoomID{"room1", "room1", "room2"} {
+		outbound, err := olm.NewOutboundGroupSession()
+		require.NoError(t, err)
+		internal, err := olm.NewInboundGroupSession([]byte(outbound.Key()))
+		require.NoError(t, err)
+		igs := &InboundGroupSession{
+			Internal:    internal,
+			SigningKey:  acc.SigningKey(),
+			SenderKey:   acc.IdentityKey(),
+			RoomID:      roomID,
+			MaxMessages: 100,
+		}
+		if i == 0 {
+			igs.KeyBackupVersion = "1"
+		}
+		require.NoError(t, store.PutGroupSession(context.TODO(), igs))
+		sessionIDs = append(sessionIDs, igs.ID())
+	}
+
+	counts, err := store.CountGroupSessionsPerRoom(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, map[id.RoomID]int{"room1": 2, "room2": 1}, counts)
+
+	all, err := store.ListGroupSessionMetadata(context.TODO(), GroupSessionMetadataQuery{})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	require.Equal(t, 100, all[0].MaxMessages)
+
+	var paged []*GroupSessionMetadata
+	query := GroupSessionMetadataQuery{Limit: 2}
+	for {
+		page, err := store.ListGroupSessionMetadata(context.TODO(), query)
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		paged = append(paged, page...)
+		query.After = page[len(page)-1].SessionID
+	}
+	require.Equal(t, all, paged)
+
+	notBackedUp, err := store.ListGroupSessionMetadata(context.TODO(), GroupSessionMetadataQuery{NotInKeyBackupVersion: "1"})
+	require.NoError(t, err)
+	require.Len(t, notBackedUp, 2)
+	for _, meta := range notBackedUp {
+		require.NotEqual(t, sessionIDs[0], meta.SessionID)
+	}
+
+	room2, err := store.ListGroupSessionMetadata(context.TODO(), GroupSessionMetadataQuery{RoomID: "room2"})
+	require.NoError(t, err)
+	require.Len(t, room2, 1)
+	require.Equal(t, sessionIDs[2], room2[0].SessionID)
+}
+
 func TestStoreOutboundMegolmSession(t *testing.T) {
 	stores := getCryptoStores(t)
 	for storeName, store := range stores {
@@ -382,3 +471,57 @@ func TestStoreSecrets(t *testing.T) {
 		})
 	}
 }
+
+func TestStoreRotatePickleKey(t *testing.T) {
+	ctx := context.TODO()
+	store := getCryptoStores(t)["sql"].(*SQLCryptoStore)
+	acc := NewOlmAccount()
+	require.NoError(t, store.PutAccount(ctx, acc))
+	olmInternal, err := olm.SessionFromPickled([]byte(olmPickled), []byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, store.AddSession(ctx, olmSessID, &OlmSession{id: olmSessID, Internal: olmInternal}))
+	outbound, err := NewOutboundGroupSession("room1", nil)
+	require.NoError(t, err)
+	require.NoError(t, store.AddOutboundGroupSession(ctx, outbound))
+	inboundInternal, err := olm.NewInboundGroupSession([]byte(outbound.Internal.Key()))
+	require.NoError(t, err)
+	require.NoError(t, store.PutGroupSession(ctx, &InboundGroupSession{
+		Internal:   inboundInternal,
+		SigningKey: acc.SigningKey(),
+		SenderKey:  acc.IdentityKey(),
+		RoomID:     "room1",
+	}))
+	require.NoError(t, store.PutSecret(ctx, id.SecretMegolmBackupV1, "trustno1"))
+
+	err = store.RotatePickleKey(ctx, []byte("wrong"), []byte("new key"))
+	require.ErrorIs(t, err, olm.ErrBadMAC)
+	require.Equal(t, []byte("test"), store.PickleKey)
+	secret, err := store.GetSecret(ctx, id.SecretMegolmBackupV1)
+	require.NoError(t, err)
+	require.Equal(t, "trustno1", secret)
+
+	require.NoError(t, store.RotatePickleKey(ctx, []byte("test"), []byte("new key")))
+	require.Equal(t, []byte("new key"), store.PickleKey)
+	store.InitFields()
+
+	retrievedAcc, err := store.GetAccount(ctx)
+	require.NoError(t, err)
+	require.Equal(t, acc.IdentityKey(), retrievedAcc.IdentityKey())
+	sessions, err := store.GetSessions(ctx, olmSessID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, id.SessionID(olmSessID), sessions[0].ID())
+	retrievedOutbound, err := store.GetOutboundGroupSession(ctx, "room1")
+	require.NoError(t, err)
+	require.Equal(t, outbound.ID(), retrievedOutbound.ID())
+	retrievedInbound, err := store.GetGroupSession(ctx, "room1", outbound.ID())
+	require.NoError(t, err)
+	require.Equal(t, outbound.ID(), retrievedInbound.ID())
+	secret, err = store.GetSecret(ctx, id.SecretMegolmBackupV1)
+	require.NoError(t, err)
+	require.Equal(t, "trustno1", secret)
+
+	store.PickleKey = []byte("test")
+	_, err = store.GetSecret(ctx, id.SecretMegolmBackupV1)
+	require.ErrorIs(t, err, olm.ErrBadMAC)
+}
@@ -11,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"go.mau.fi/util/exhttp"
 	"golang.org/x/exp/maps"
@@ -192,3 +193,31 @@ func (e RespError) Is(err error) bool {
 	}
 	return e2.ErrCode == e.ErrCode
 }
+
+// RetryAfter returns the retry_after_ms field of the error, which is usually present in M_LIMIT_EXCEEDED errors.
+// The second return value is false if the field is not present.
+//
+// Note that the field is deprecated in favor of the Retry-After header since Matrix v1.10,
+// but older servers may only include it in the response body.
+func (e RespError) RetryAfter() (time.Duration, bool) {
+	retryAfterMS, ok := e.ExtraData["retry_after_ms"].(float64)
+	if !ok || retryAfterMS < 0 {
+		return 0, false
+	}
+	return time.Duration(retryAfterMS) * time.Millisecond, true
+}
+
+// IsSoftLogout returns true if the error is an M_UNKNOWN_TOKEN error with the soft_logout flag set.
+//
+// Soft logouts mean the client should re-authenticate while keeping the same device ID and local (crypto) state.
+// See https://spec.matrix.org/v1.11/client-server-api/#soft-logout
+func (e RespError) IsSoftLogout() bool {
+	softLogout, _ := e.ExtraData["soft_logout"].(bool)
+	return e.ErrCode == MUnknownToken.ErrCode && softLogout
+}
+
+// IsSoftLogout checks if the given error contains a soft logout RespError. See [RespError.IsSoftLogout] for more info.
+func IsSoftLogout(err error) bool {
+	var respErr RespError
+	return errors.As(err, &respErr) && respErr.IsSoftLogout()
+}
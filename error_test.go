@@ -0,0 +1,47 @@
+// Copyright (c) 2025 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestRespError_RetryAfter(t *testing.T) {
+	var respErr mautrix.RespError
+	err := json.Unmarshal([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests","retry_after_ms":2500}`), &respErr)
+	require.NoError(t, err)
+	retryAfter, ok := respErr.RetryAfter()
+	assert.True(t, ok)
+	assert.Equal(t, 2500*time.Millisecond, retryAfter)
+
+	respErr = mautrix.RespError{}
+	err = json.Unmarshal([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests"}`), &respErr)
+	require.NoError(t, err)
+	_, ok = respErr.RetryAfter()
+	assert.False(t, ok)
+}
+
+func TestIsSoftLogout(t *testing.T) {
+	var respErr mautrix.RespError
+	err := json.Unmarshal([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"Token expired","soft_logout":true}`), &respErr)
+	require.NoError(t, err)
+	assert.True(t, respErr.IsSoftLogout())
+	assert.True(t, mautrix.IsSoftLogout(mautrix.HTTPError{RespError: &respErr}))
+
+	respErr = mautrix.RespError{}
+	err = json.Unmarshal([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"Invalid token"}`), &respErr)
+	require.NoError(t, err)
+	assert.False(t, respErr.IsSoftLogout())
+	assert.False(t, mautrix.IsSoftLogout(mautrix.HTTPError{RespError: &respErr}))
+}
@@ -111,6 +111,11 @@ type MautrixInfo struct {
 	ForwardedKeys bool
 	WasEncrypted  bool
 	TrustSource   *id.Device
+	// UnverifiedSource is set if the Megolm session was restored from key backup and the claimed sender keys
+	// couldn't be confirmed against a known device when it was imported. It doesn't affect TrustState.
+	UnverifiedSource bool
+	// The Megolm message index of the event, only set for events decrypted with Megolm.
+	MegolmMessageIndex uint
 
 	ReceivedAt         time.Time
 	EditedAt           time.Time
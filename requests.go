@@ -92,6 +92,15 @@ type ReqLogin struct {
 	StoreHomeserverURL bool `json:"-"`
 }
 
+// ReqRefresh is the JSON request for https://spec.matrix.org/v1.11/client-server-api/#post_matrixclientv3refresh
+type ReqRefresh struct {
+	// The refresh token to use. If empty, Client.RefreshToken is used.
+	RefreshToken string `json:"refresh_token"`
+
+	// Whether or not the returned access and refresh tokens should be stored in the Client
+	StoreCredentials bool `json:"-"`
+}
+
 type ReqPutDevice struct {
 	DisplayName string `json:"display_name,omitempty"`
 }
@@ -380,6 +389,14 @@ type ReqPutPushRule struct {
 	Pattern    string                     `json:"pattern"`
 }
 
+type ReqPushRuleEnabled struct {
+	Enabled bool `json:"enabled"`
+}
+
+type ReqPushRuleActions struct {
+	Actions pushrules.PushActionArray `json:"actions"`
+}
+
 // Deprecated: MSC2716 was abandoned
 type ReqBatchSend struct {
 	PrevEventID id.EventID `json:"-"`
@@ -587,3 +604,32 @@ func (rgr *ReqGetRelations) Query() map[string]string {
 	}
 	return query
 }
+
+type ThumbnailMethod string
+
+const (
+	ThumbnailMethodCrop  ThumbnailMethod = "crop"
+	ThumbnailMethodScale ThumbnailMethod = "scale"
+)
+
+// ReqThumbnail contains the query parameters for https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv1mediathumbnailservernamemediaid
+type ReqThumbnail struct {
+	Width    int
+	Height   int
+	Method   ThumbnailMethod
+	Animated bool
+}
+
+func (req *ReqThumbnail) Query() map[string]string {
+	query := map[string]string{
+		"width":  strconv.Itoa(req.Width),
+		"height": strconv.Itoa(req.Height),
+	}
+	if req.Method != "" {
+		query["method"] = string(req.Method)
+	}
+	if req.Animated {
+		query["animated"] = "true"
+	}
+	return query
+}
@@ -8,6 +8,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
@@ -251,6 +252,18 @@ type RespRegister struct {
 
 type LoginFlow struct {
 	Type AuthType `json:"type"`
+
+	// IdentityProviders is the list of SSO identity providers for m.login.sso flows.
+	IdentityProviders []SSOIdentityProvider `json:"identity_providers,omitempty"`
+}
+
+// SSOIdentityProvider is an identity provider in a m.login.sso flow.
+// See https://spec.matrix.org/v1.11/client-server-api/#client-login-via-sso
+type SSOIdentityProvider struct {
+	ID    string        `json:"id"`
+	Name  string        `json:"name"`
+	Icon  id.ContentURI `json:"icon,omitempty"`
+	Brand string        `json:"brand,omitempty"`
 }
 
 // RespLoginFlows is the JSON response for https://spec.matrix.org/v1.2/client-server-api/#get_matrixclientv3login
@@ -284,6 +297,25 @@ type RespLogin struct {
 	ExpiresInMS  int64  `json:"expires_in_ms,omitempty"`
 }
 
+// ExpiresIn returns the duration after which the access token will expire, counted from when the response
+// was received, or zero if it doesn't expire.
+func (rl *RespLogin) ExpiresIn() time.Duration {
+	return time.Duration(max(rl.ExpiresInMS, 0)) * time.Millisecond
+}
+
+// RespRefresh is the JSON response for https://spec.matrix.org/v1.11/client-server-api/#post_matrixclientv3refresh
+type RespRefresh struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresInMS  int64  `json:"expires_in_ms,omitempty"`
+}
+
+// ExpiresIn returns the duration after which the new access token will expire, counted from when the response
+// was received, or zero if it doesn't expire.
+func (rr *RespRefresh) ExpiresIn() time.Duration {
+	return time.Duration(max(rr.ExpiresInMS, 0)) * time.Millisecond
+}
+
 // RespLogout is the JSON response for https://spec.matrix.org/v1.2/client-server-api/#post_matrixclientv3logout
 type RespLogout struct{}
 
@@ -7,11 +7,16 @@
 package mautrix_test
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
 )
 
 func TestClient_BuildURL(t *testing.T) {
@@ -63,3 +68,68 @@ func TestClient_BuildURL_MissingSchemeWithPath(t *testing.T) {
 	built := cli.BuildClientURL("v3", "foo/bar%2F🐈 1", "hello", "world")
 	assert.Equal(t, "https://example.com/base/_matrix/client/v3/foo%2Fbar%252F%F0%9F%90%88%201/hello/world", built)
 }
+
+func TestClient_BuildSSORedirectURL(t *testing.T) {
+	cli, err := mautrix.NewClient("https://example.com", "", "")
+	assert.NoError(t, err)
+	built := cli.BuildSSORedirectURL("", "https://client.example.com/callback?a=b")
+	assert.Equal(t, "https://example.com/_matrix/client/v3/login/sso/redirect?redirectUrl=https%3A%2F%2Fclient.example.com%2Fcallback%3Fa%3Db", built)
+	built = cli.BuildSSORedirectURL("oidc-github", "https://client.example.com/callback")
+	assert.Equal(t, "https://example.com/_matrix/client/v3/login/sso/redirect/oidc-github?redirectUrl=https%3A%2F%2Fclient.example.com%2Fcallback", built)
+}
+
+func TestClient_ThumbnailURL(t *testing.T) {
+	cli, err := mautrix.NewClient("https://example.com", "", "")
+	assert.NoError(t, err)
+	built := cli.ThumbnailURL(id.ContentURI{Homeserver: "example.org", FileID: "abc"}, &mautrix.ReqThumbnail{
+		Width:    64,
+		Height:   32,
+		Method:   mautrix.ThumbnailMethodCrop,
+		Animated: true,
+	})
+	assert.Equal(t, "https://example.com/_matrix/client/v1/media/thumbnail/example.org/abc?animated=true&height=32&method=crop&width=64", built)
+}
+
+func TestClient_MediaEndpoints(t *testing.T) {
+	var versionRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_matrix/client/versions" {
+			versionRequests++
+			_, _ = w.Write([]byte(`{"versions": ["v1.10"]}`))
+			return
+		}
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+	cli, err := mautrix.NewClient(server.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	mxc := id.ContentURI{Homeserver: "example.org", FileID: "abc"}
+
+	data, err := cli.DownloadBytes(context.TODO(), mxc)
+	require.NoError(t, err)
+	assert.Equal(t, "/_matrix/client/v1/media/download/example.org/abc", string(data))
+
+	supported, err := cli.SupportsAuthenticatedMedia(context.TODO())
+	require.NoError(t, err)
+	assert.False(t, supported)
+	assert.Equal(t, 1, versionRequests)
+	data, err = cli.DownloadBytes(context.TODO(), mxc)
+	require.NoError(t, err)
+	assert.Equal(t, "/_matrix/media/v3/download/example.org/abc", string(data))
+	data, err = cli.ThumbnailBytes(context.TODO(), mxc, &mautrix.ReqThumbnail{Width: 32, Height: 32})
+	require.NoError(t, err)
+	assert.Equal(t, "/_matrix/media/v3/thumbnail/example.org/abc", string(data))
+
+	cli.MediaEndpoints = mautrix.MediaEndpointsAuthenticated
+	data, err = cli.ThumbnailBytes(context.TODO(), mxc, &mautrix.ReqThumbnail{Width: 32, Height: 32})
+	require.NoError(t, err)
+	assert.Equal(t, "/_matrix/client/v1/media/thumbnail/example.org/abc", string(data))
+
+	cli.SpecVersions = &mautrix.RespVersions{Versions: []mautrix.SpecVersion{mautrix.SpecV111}}
+	cli.MediaEndpoints = mautrix.MediaEndpointsLegacy
+	supported, err = cli.SupportsAuthenticatedMedia(context.TODO())
+	require.NoError(t, err)
+	assert.False(t, supported)
+	assert.Equal(t, 1, versionRequests)
+}